@@ -0,0 +1,165 @@
+package crawl
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Certificate describes the certificate a server presented and whether it verified.
+// It is recorded whether or not Config.VerifyTLS is set.
+type Certificate struct {
+	// Subject and Issuer are the distinguished names of the leaf certificate and its issuer.
+	Subject string
+	Issuer  string
+
+	// SANs are the DNS names and IP addresses the leaf certificate is valid for.
+	SANs []string
+
+	// NotBefore and NotAfter are the validity period of the leaf certificate.
+	NotBefore time.Time
+	NotAfter  time.Time
+
+	// VerifyError is the error from validating the chain against the root CAs,
+	// e.g. an expired or self-signed certificate, or nil if the chain is valid.
+	// It does not include hostname mismatches, see HostnameMatch.
+	VerifyError error
+
+	// HostnameMatch reports whether the leaf certificate is valid for the requested host.
+	HostnameMatch bool
+}
+
+// Expired reports whether the leaf certificate has expired.
+func (c *Certificate) Expired() bool {
+	return time.Now().After(c.NotAfter)
+}
+
+// certificateFor describes the certificates presented by host, with the first being
+// the leaf. The chain is verified with v.
+func certificateFor(certs []*x509.Certificate, host string, v *certVerifier) *Certificate {
+	if len(certs) == 0 {
+		return nil
+	}
+	leaf := certs[0]
+
+	cert := &Certificate{
+		Subject:       leaf.Subject.String(),
+		Issuer:        leaf.Issuer.String(),
+		SANs:          append([]string{}, leaf.DNSNames...),
+		NotBefore:     leaf.NotBefore,
+		NotAfter:      leaf.NotAfter,
+		HostnameMatch: leaf.VerifyHostname(host) == nil,
+		VerifyError:   v.verify(certs),
+	}
+	for _, ip := range leaf.IPAddresses {
+		cert.SANs = append(cert.SANs, ip.String())
+	}
+	return cert
+}
+
+// certificateFromError describes the certificates of a failed verification, or returns nil
+// if err is not a certificate verification error.
+func certificateFromError(err error, v *certVerifier) *Certificate {
+	var certErr *tls.CertificateVerificationError
+	var urlErr *url.Error
+	if !errors.As(err, &certErr) || !errors.As(err, &urlErr) {
+		return nil
+	}
+	u, perr := url.Parse(urlErr.URL)
+	if perr != nil {
+		return nil
+	}
+	return certificateFor(certErr.UnverifiedCertificates, u.Hostname(), v)
+}
+
+const (
+	// certVerifyTTL is how long the verification of a chain is cached.
+	certVerifyTTL = time.Hour
+	// minCertPrune is the cache size below which expired verifications are kept.
+	minCertPrune = 1000
+)
+
+// certVerifier verifies certificate chains against the root CAs. The outcome is cached
+// per chain, so responses on a reused connection, or from hosts sharing a certificate,
+// do not verify it again.
+type certVerifier struct {
+	roots *x509.CertPool // nil for the system roots
+	now   func() time.Time
+
+	mu      sync.Mutex
+	cache   map[[sha256.Size]byte]certVerification // by hash of the chain
+	pruneAt int                                    // cache size at which expired entries are dropped
+}
+
+// certVerification is the cached outcome of verifying a chain.
+type certVerification struct {
+	err     error
+	expires time.Time
+}
+
+func newCertVerifier(roots *x509.CertPool) *certVerifier {
+	return &certVerifier{
+		roots:   roots,
+		now:     time.Now,
+		cache:   make(map[[sha256.Size]byte]certVerification),
+		pruneAt: minCertPrune,
+	}
+}
+
+// verify returns the error from validating the chain, with the first certificate being the leaf.
+func (v *certVerifier) verify(certs []*x509.Certificate) error {
+	h := sha256.New()
+	for _, c := range certs {
+		h.Write(c.Raw) //nolint:errcheck
+	}
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+
+	now := v.now()
+	v.mu.Lock()
+	if e, ok := v.cache[key]; ok && now.Before(e.expires) {
+		v.mu.Unlock()
+		return e.err
+	}
+	v.mu.Unlock()
+
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+	})
+
+	// A valid chain is verified again once a certificate in it expires
+	expires := now.Add(certVerifyTTL)
+	for _, c := range certs {
+		if c.NotAfter.Before(expires) {
+			expires = c.NotAfter
+		}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.cache) >= v.pruneAt {
+		v.prune(now)
+	}
+	v.cache[key] = certVerification{err: err, expires: expires}
+	return err
+}
+
+// prune drops expired entries, and sets the size for the next prune. Must be called with mu held.
+func (v *certVerifier) prune(now time.Time) {
+	for key, e := range v.cache {
+		if !now.Before(e.expires) {
+			delete(v.cache, key)
+		}
+	}
+	v.pruneAt = max(minCertPrune, 2*len(v.cache))
+}
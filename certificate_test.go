@@ -0,0 +1,121 @@
+package crawl
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCertificate(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	crawlWith := func(config Config) (*Result, error) {
+		var result *Result
+		var failure error
+		config.UserAgent = "test"
+		config.ResultHandler = func(res *Result) error {
+			result = res
+			return nil
+		}
+		config.ErrorHandler = func(url string, err error) { failure = err }
+		crawler := New(ctx, config)
+		if err := crawler.Run(ctx, func(yield func(string) bool) { yield(server.URL) }); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return result, failure
+	}
+
+	t.Run("insecure by default", func(t *testing.T) {
+		res, err := crawlWith(Config{})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		cert := res.Certificate
+		if cert == nil {
+			t.Fatal("expected certificate details")
+		}
+		if cert.VerifyError == nil {
+			t.Error("expected a verification error for the test certificate")
+		}
+		if !cert.HostnameMatch {
+			t.Error("expected hostname 127.0.0.1 to match")
+		}
+		if !slices.Contains(cert.SANs, "127.0.0.1") || !slices.Contains(cert.SANs, "example.com") {
+			t.Errorf("expected SANs to include 127.0.0.1 and example.com, got %v", cert.SANs)
+		}
+		if cert.Expired() || cert.Subject == "" || cert.Issuer == "" {
+			t.Errorf("unexpected certificate details: %+v", cert)
+		}
+	})
+
+	t.Run("verification failure", func(t *testing.T) {
+		res, err := crawlWith(Config{VerifyTLS: true})
+		if res != nil || err == nil {
+			t.Fatalf("expected the request to fail, got %v", err)
+		}
+		var certErr *tls.CertificateVerificationError
+		if !errors.As(err, &certErr) {
+			t.Errorf("expected a certificate verification error, got %v", err)
+		}
+	})
+
+	t.Run("trusted by the client", func(t *testing.T) {
+		client := server.Client()
+		transport := client.Transport.(*http.Transport)
+
+		res, err := crawlWith(Config{VerifyTLS: true, Client: client})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if res.Certificate == nil || res.Certificate.VerifyError != nil {
+			t.Errorf("expected a valid certificate, got %+v", res.Certificate)
+		}
+		if client.Transport != transport || transport.TLSClientConfig.InsecureSkipVerify {
+			t.Error("expected the client's transport not to be modified")
+		}
+	})
+}
+
+func TestCertVerifier(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	chain := []*x509.Certificate{server.Certificate()}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	if err := newCertVerifier(roots).verify(chain); err != nil {
+		t.Errorf("expected a trusted chain to verify, got %v", err)
+	}
+
+	now := time.Now()
+	v := newCertVerifier(nil)
+	v.now = func() time.Time { return now }
+	if err := v.verify(chain); err == nil {
+		t.Fatal("expected an untrusted chain to fail")
+	}
+	if len(v.cache) != 1 {
+		t.Fatalf("expected 1 cached verification, got %d", len(v.cache))
+	}
+
+	// Later responses with the same chain get the cached outcome until it expires
+	cached := errors.New("cached")
+	for key, e := range v.cache {
+		v.cache[key] = certVerification{err: cached, expires: e.expires}
+	}
+	if err := v.verify(chain); err != cached {
+		t.Errorf("expected the cached outcome, got %v", err)
+	}
+	now = now.Add(certVerifyTTL)
+	if err := v.verify(chain); err == nil || err == cached {
+		t.Errorf("expected the chain to be verified again, got %v", err)
+	}
+}
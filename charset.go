@@ -0,0 +1,81 @@
+package crawl
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// charsetPeek is the number of bytes inspected for a BOM, <meta> tag or content sniffing.
+const charsetPeek = 1024
+
+// decodeCharset converts a text response body to UTF-8. The charset is determined
+// from a BOM, the Content-Type, a <meta> tag in the first 1024 bytes or the content,
+// and recorded in res. The Content-Type is updated to say charset=utf-8.
+// Other media types, such as images and JSON, are left alone.
+func decodeCharset(resp *http.Response, res *Result) {
+	body := resp.Body
+	br := bufio.NewReaderSize(body, charsetPeek)
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		preview, _ := br.Peek(charsetPeek)
+		contentType = http.DetectContentType(preview)
+		resp.Body = readCloser{br, body}
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !isText(mediaType) {
+		return
+	}
+	preview, _ := br.Peek(charsetPeek)
+
+	enc, name, certain := charset.DetermineEncoding(preview, contentType)
+	// Without any declaration, HTML defaults to windows-1252. Pages that are ASCII
+	// so far are far more likely to be UTF-8 nowadays.
+	if !certain && isASCII(preview) && !bytes.Contains(bytes.ToLower(preview), []byte("charset")) {
+		enc, name = encoding.Nop, "utf-8"
+	}
+	res.Charset = name
+
+	// BOMOverride also strips the BOM
+	r := transform.NewReader(br, unicode.BOMOverride(enc.NewDecoder()))
+	resp.Body = readCloser{r, body}
+	params["charset"] = "utf-8"
+	resp.Header.Set("Content-Type", mime.FormatMediaType(mediaType, params))
+}
+
+// isText reports whether a media type is text that a charset applies to.
+func isText(mediaType string) bool {
+	switch {
+	case strings.HasPrefix(mediaType, "text/"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	return mediaType == "application/xml"
+}
+
+// isASCII reports whether b only contains 7-bit characters.
+func isASCII(b []byte) bool {
+	for _, c := range b {
+		if c >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// readCloser reads from a wrapper of a body and closes the body itself.
+type readCloser struct {
+	io.Reader
+	body io.Closer
+}
+
+func (r readCloser) Close() error {
+	return r.body.Close()
+}
@@ -0,0 +1,76 @@
+package crawl
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDecodeCharset(t *testing.T) {
+	ctx := context.Background()
+
+	pages := map[string]struct {
+		contentType string
+		body        string
+	}{
+		"/latin1":   {"text/html; charset=ISO-8859-1", "<p>caf\xe9</p>"},
+		"/meta":     {"text/html", `<meta charset="shift_jis"><p>` + "\x93\xfa\x96\x7b" + "</p>"},
+		"/bom":      {"text/plain", "\xff\xfec\x00a\x00f\x00\xe9\x00"},
+		"/sniffed":  {"text/html", "<p>caf\xe9</p>"},
+		"/ascii":    {"", "<html><p>cafe</p></html>"},
+		"/image":    {"image/png", "\x89PNG\r\n\x1a\n\xe9"},
+		"/utf8-tag": {"text/html", "<p>café</p>"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := pages[r.URL.Path]
+		w.Header()["Content-Type"] = []string{page.contentType}
+		io.WriteString(w, page.body) //nolint:errcheck
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path        string
+		charset     string
+		body        string
+		contentType string
+	}{
+		{"/latin1", "windows-1252", "<p>café</p>", "text/html; charset=utf-8"},
+		{"/meta", "shift_jis", `<meta charset="shift_jis"><p>日本</p>`, "text/html; charset=utf-8"},
+		{"/bom", "utf-16le", "café", "text/plain; charset=utf-8"},
+		{"/sniffed", "windows-1252", "<p>café</p>", "text/html; charset=utf-8"},
+		{"/ascii", "utf-8", "<html><p>cafe</p></html>", "text/html; charset=utf-8"},
+		{"/image", "", "\x89PNG\r\n\x1a\n\xe9", "image/png"},
+		{"/utf8-tag", "utf-8", "<p>café</p>", "text/html; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			var res *Result
+			var body string
+			crawler := New(ctx, Config{
+				UserAgent:     "test",
+				DecodeCharset: true,
+				ResultHandler: func(r *Result) error {
+					data, err := io.ReadAll(r.Response.Body)
+					res, body = r, string(data)
+					return err
+				},
+				ErrorHandler: func(url string, err error) { t.Errorf("unexpected error for %s: %v", url, err) },
+			})
+			if err := crawler.Run(ctx, func(yield func(string) bool) { yield(server.URL + tt.path) }); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if res.Charset != tt.charset {
+				t.Errorf("expected charset %q, got %q", tt.charset, res.Charset)
+			}
+			if body != tt.body {
+				t.Errorf("expected body %q, got %q", tt.body, body)
+			}
+			if ct := res.Response.Header.Get("Content-Type"); ct != tt.contentType {
+				t.Errorf("expected Content-Type %q, got %q", tt.contentType, ct)
+			}
+		})
+	}
+}
@@ -1,20 +1,29 @@
 package crawl
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
+	"io"
+	"mime"
 	"net/http"
+	"net/http/httptrace"
+	"strings"
 	"sync"
 )
 
 const defaultMaxRedirects = 3
 
 // New creates a new Crawler with the given configuration.
-// It fetches the user agent from the API if not provided in the config.
+// It gets the user agent from the UserAgentProvider if not provided in the config.
 func New(ctx context.Context, config Config) *Crawler {
 	if config.WorkerCount <= 0 {
 		config.WorkerCount = 10
 	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 100 * config.WorkerCount
+	}
 
 	if config.RequestBuilder == nil {
 		config.RequestBuilder = DefaultRequestBuilder
@@ -38,50 +47,181 @@ func New(ctx context.Context, config Config) *Crawler {
 	if clientCopy.CheckRedirect == nil {
 		clientCopy.CheckRedirect = config.RedirectionPolicy
 	}
+	clientCopy.CheckRedirect = recordRedirects(clientCopy.CheckRedirect)
+
+	// Use the TLS config of the caller's transport, without modifying it
+	tlsConfig := &tls.Config{}
+	transport, _ := clientCopy.Transport.(*http.Transport)
+	if transport != nil && transport.TLSClientConfig != nil {
+		tlsConfig = transport.TLSClientConfig.Clone()
+	}
+	tlsConfig.InsecureSkipVerify = !config.VerifyTLS
 
-	if clientCopy.Transport == nil {
-		clientCopy.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
+	switch {
+	case config.OrderedHeaders:
+		ordered := newOrderedTransport(tlsConfig)
+		if config.ProxyPool != nil {
+			ordered.proxy = config.ProxyPool.proxyFor
+		}
+		if config.Resolver != nil {
+			ordered.dialer = config.Resolver
+		}
+		clientCopy.Transport = ordered
+	case clientCopy.Transport == nil:
+		clientCopy.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	case transport != nil:
+		transport = transport.Clone()
+		transport.TLSClientConfig = tlsConfig
+		clientCopy.Transport = transport
+	}
+	if t, ok := clientCopy.Transport.(*http.Transport); ok {
+		if config.ProxyPool != nil {
+			t.Proxy = config.ProxyPool.proxyFor
+			t.OnProxyConnectResponse = proxyConnectResponse
 		}
-	} else if transport, ok := clientCopy.Transport.(*http.Transport); ok {
-		if transport.TLSClientConfig == nil {
-			transport.TLSClientConfig = &tls.Config{}
+		if config.Resolver != nil {
+			t.DialContext = config.Resolver.DialContext
 		}
-		transport.TLSClientConfig.InsecureSkipVerify = true
 	}
 
 	client = &clientCopy
 
-	userAgent := getUserAgent(ctx, config)
-	secChUa := generateSecChUa(userAgent)
+	profiles := config.HeaderProfiles
+	var userAgent string
+	if len(profiles) > 0 {
+		userAgent = profiles[0].UserAgent()
+	} else {
+		userAgent = getUserAgent(ctx, config)
+		profiles = []*HeaderProfile{defaultProfile(userAgent)}
+	}
 
-	return &Crawler{
+	c := &Crawler{
 		config:    config,
 		userAgent: userAgent,
-		secChUa:   secChUa,
+		profiles:  profiles,
 		client:    client,
+		verifier:  newCertVerifier(tlsConfig.RootCAs),
+		handler:   config.ResultHandler,
+	}
+	c.fetch = chain(config.Middleware, c.send)
+	if c.handler == nil {
+		responseHandler := config.ResponseHandler
+		c.handler = func(res *Result) error {
+			return responseHandler(res.URL, res.Response)
+		}
 	}
+	if config.Robots != nil {
+		c.robots = newRobotsCache(*config.Robots, client, userAgent, config.Timeouts)
+	}
+	return c
+}
+
+// run holds the state of a single call to Run or Results.
+type run struct {
+	sched    *scheduler
+	frontier *frontier // nil unless following links
+	handle   ResultHandler
+	fail     func(res *Result, err error)
+	stats    *runStats
 }
 
 // Run starts crawling URLs from the generator with N parallel workers.
+// URLs are dispatched through a per-host scheduler, see Config.MaxPerHost and Config.HostDelay.
 func (c *Crawler) Run(ctx context.Context, urlGen URLGenerator) error {
-	urlChan := make(chan string, c.config.WorkerCount*2)
+	return c.RunItems(ctx, URLItems(urlGen))
+}
+
+// RunItems is Run for items with metadata. Links found with Config.FollowLinks inherit
+// the metadata of the page they were found on. URLs requeued from the Journal have none.
+func (c *Crawler) RunItems(ctx context.Context, items ItemGenerator) error {
+	return c.crawl(ctx, items, c.handler, func(res *Result, err error) {
+		c.config.ErrorHandler(res.URL, err)
+	}, newRunStats())
+}
+
+// URLItems turns URLs into items without metadata.
+func URLItems(urlGen URLGenerator) ItemGenerator {
+	return func(yield func(Item) bool) {
+		for url := range urlGen {
+			if !yield(Item{URL: url}) {
+				return
+			}
+		}
+	}
+}
+
+// crawl crawls items from the generator, passing results and errors to the given handlers
+// and recording statistics in s.
+func (c *Crawler) crawl(ctx context.Context, items ItemGenerator, handle ResultHandler, fail func(*Result, error), s *runStats) error {
+	c.stats.Store(s)
+	defer s.finish()
+
+	r := &run{
+		sched: newScheduler(c.config),
+		handle: func(res *Result) error {
+			s.response(res)
+			return handle(res)
+		},
+		fail: func(res *Result, err error) {
+			var e *Error
+			if errors.As(err, &e) && e.Meta == nil {
+				e.Meta = res.Meta
+			}
+			res.failed = true
+			s.failure(err)
+			c.config.Metrics.failure(err)
+			fail(res, err)
+		},
+		stats: s,
+	}
+	if c.config.FollowLinks != nil {
+		r.frontier = newFrontier(*c.config.FollowLinks)
+	}
+	c.config.Metrics.register(r.sched)
+	defer c.config.Metrics.unregister(r.sched)
+
+	// URLs requeued from the journal, so they are skipped when the generator yields them again
+	requeued := make(map[string]struct{})
+	if j := c.config.Journal; j != nil {
+		if c.config.Resume {
+			for _, e := range j.inflight() {
+				requeued[journalKey(e.url)] = struct{}{}
+				if r.frontier != nil {
+					r.frontier.seed(e.url)
+				}
+				r.sched.add(&task{url: e.url, attempt: 1, depth: e.depth})
+			}
+		} else if err := j.reset(); err != nil {
+			return err
+		}
+	}
+
 	var wg sync.WaitGroup
 
 	for i := 0; i < c.config.WorkerCount; i++ {
 		wg.Add(1)
-		go c.worker(ctx, urlChan, &wg)
+		go c.worker(ctx, r, i, &wg)
 	}
 
 	go func() {
-		defer close(urlChan)
-		for url := range urlGen {
-			select {
-			case <-ctx.Done():
+		defer r.sched.close()
+		for item := range items {
+			url := item.URL
+			if j := c.config.Journal; j != nil {
+				if c.config.Resume {
+					if _, ok := requeued[journalKey(url)]; ok || j.isDone(url) {
+						continue
+					}
+				}
+				if err := j.markPending(url, 0); err != nil {
+					r.fail(&Result{URL: url, Meta: item.Meta}, err)
+				}
+			}
+			if r.frontier != nil {
+				r.frontier.seed(url)
+			}
+			if !r.sched.push(ctx, &task{url: url, attempt: 1, meta: item.Meta}) {
 				return
-			case urlChan <- url:
 			}
 		}
 	}()
@@ -90,71 +230,253 @@ func (c *Crawler) Run(ctx context.Context, urlGen URLGenerator) error {
 	return ctx.Err()
 }
 
-// worker processes URLs from the channel.
-func (c *Crawler) worker(ctx context.Context, urlChan <-chan string, wg *sync.WaitGroup) {
+// worker processes URLs handed out by the scheduler. id numbers the workers of a run.
+func (c *Crawler) worker(ctx context.Context, r *run, id int, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	for {
-		select {
-		case <-ctx.Done():
+		t, ok := r.sched.next(ctx)
+		if !ok {
 			return
-		case url, ok := <-urlChan:
-			if !ok {
-				return
+		}
+		c.config.Metrics.working(id, 1)
+		res := &Result{URL: t.url, Attempts: t.attempt, Meta: t.meta}
+		requeued := c.processURL(ctx, r, t, res)
+		c.config.Metrics.working(id, -1)
+		r.stats.completed(res, requeued)
+		if requeued {
+			c.config.Metrics.retry()
+		}
+		// Interrupted URLs stay pending in the journal, to be requeued on resume
+		if j := c.config.Journal; j != nil && !requeued && ctx.Err() == nil {
+			if err := j.markDone(t.url); err != nil {
+				r.fail(&Result{URL: t.url, Meta: t.meta}, err)
 			}
-			c.processURL(ctx, url)
 		}
+		r.sched.done(t)
 	}
 }
 
-// processURL handles a single URL: builds request, sends it, and handles response.
-func (c *Crawler) processURL(ctx context.Context, url string) {
-	req, err := c.config.RequestBuilder(ctx, url)
+// processURL handles a single URL: builds request, sends it, and handles response,
+// recording the outcome in res. If the RetryPolicy asks for another attempt, the task
+// is put back into the scheduler and processURL returns true.
+func (c *Crawler) processURL(ctx context.Context, r *run, t *task, res *Result) bool {
+	url := t.url
+
+	req, err := c.config.RequestBuilder(withMeta(ctx, t.meta), url)
 	if err != nil {
-		c.config.ErrorHandler(url, err)
-		return
+		r.fail(res, newError(KindRequestBuild, url, err))
+		return false
 	}
 
-	// Set Chrome-like headers if not already set by RequestBuilder
-	setHeaderIfNotExists := func(key, value string) {
-		if req.Header.Get(key) == "" {
-			req.Header.Set(key, value)
+	if c.robots != nil {
+		if err := c.checkRobots(ctx, r.sched, t, req.URL); err != nil {
+			r.fail(res, newError(KindPolicy, url, err))
+			return false
 		}
 	}
 
-	// Always override User-Agent with our configured one
-	req.Header.Set("User-Agent", c.userAgent)
+	// Set browser headers if not already set by RequestBuilder. The User-Agent always
+	// comes from the profile, so it matches the other headers. Like net/http, responses
+	// are only decoded if the crawler asked for compression itself.
+	profile := c.profileFor(t.host)
+	decode := false
+	for _, f := range profile.Headers {
+		switch {
+		case f.Name == "User-Agent":
+			req.Header.Set(f.Name, f.Value)
+		case f.Name == "Connection":
+			// Left to the transport, which manages connections
+		case f.Name == "Accept-Encoding":
+			if ae := supportedEncodings(f.Value); ae != "" && req.Header.Get(f.Name) == "" {
+				req.Header.Set(f.Name, ae)
+				decode = true
+			}
+		case req.Header.Get(f.Name) == "":
+			req.Header.Set(f.Name, f.Value)
+		}
+	}
 
-	// Standard Chrome headers
-	setHeaderIfNotExists("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7")
-	setHeaderIfNotExists("Accept-Language", "en-GB,en-US;q=0.9,en;q=0.8,nl;q=0.7,sv;q=0.6")
-	setHeaderIfNotExists("Cache-Control", "no-cache")
-	setHeaderIfNotExists("Pragma", "no-cache")
-	setHeaderIfNotExists("Priority", "u=0, i")
-	setHeaderIfNotExists("Referer", "https://www.google.com/")
-	setHeaderIfNotExists("Sec-Ch-Ua", c.secChUa)
-	setHeaderIfNotExists("Sec-Ch-Ua-Mobile", "?0")
-	setHeaderIfNotExists("Sec-Ch-Ua-Platform", `"macOS"`)
-	setHeaderIfNotExists("Sec-Fetch-Dest", "document")
-	setHeaderIfNotExists("Sec-Fetch-Mode", "navigate")
-	setHeaderIfNotExists("Sec-Fetch-Site", "same-origin")
-	setHeaderIfNotExists("Sec-Fetch-User", "?1")
-	setHeaderIfNotExists("Upgrade-Insecure-Requests", "1")
+	reqCtx := req.Context()
+	var dl *deadlines
+	if c.config.Timeouts != (Timeouts{}) {
+		reqCtx, dl = withDeadlines(reqCtx, c.config.Timeouts)
+		defer dl.close()
+	}
+
+	tr := newTracer()
+	req = req.WithContext(withProfile(withResult(httptrace.WithClientTrace(reqCtx, tr.trace()), res), profile))
 
-	resp, err := c.client.Do(req)
+	f := &Fetch{URL: url, Meta: t.meta, Attempt: t.attempt, Depth: t.depth, Request: req, Result: res}
+	resp, err := c.fetch(f)
 	if err != nil {
-		c.config.ErrorHandler(url, err)
-		return
+		err = newError(KindMiddleware, url, err)
+	} else if resp == nil {
+		err = newError(KindMiddleware, url, errors.New("middleware returned no response"))
+	} else if resp.Request == nil {
+		// A response made up by middleware, for the request as the middleware left it
+		resp.Request = f.Request
+		if resp.Body == nil {
+			resp.Body = http.NoBody
+		}
+		if resp.Header == nil {
+			resp.Header = http.Header{}
+		}
 	}
+	c.config.Metrics.attempt(res, resp, err)
+	if res.proxy != nil && ctx.Err() == nil {
+		// Errors of the target or of middleware, such as filtered responses, do not count against the proxy
+		var proxyErr error
+		if isProxyFailure(err) {
+			proxyErr = err
+		}
+		c.config.ProxyPool.report(res.proxy, proxyErr)
+	}
+	if c.retry(ctx, r.sched, t, resp, err) {
+		return true
+	}
+	if err != nil {
+		tr.fill(res, nil)
+		res.Certificate = certificateFromError(err, c.verifier)
+		r.fail(res, err)
+		return false
+	}
+	tr.fill(res, resp)
+	if resp.TLS != nil {
+		res.Certificate = certificateFor(resp.TLS.PeerCertificates, resp.Request.URL.Hostname(), c.verifier)
+	}
+	resp.Body = &receivedBody{ReadCloser: resp.Body, res: res}
+	if decode {
+		decodeBody(resp, res)
+	}
+	if dl != nil {
+		dl.start(PhaseBodyRead, c.config.Timeouts.BodyRead)
+		resp.Body = &deadlineBody{ReadCloser: resp.Body, ctx: reqCtx, d: dl, url: url}
+	}
+	if c.config.DecodeCharset {
+		decodeCharset(resp, res)
+	}
+	if c.config.MaxBodyBytes > 0 {
+		resp.Body = &limitedBody{ReadCloser: resp.Body, res: res, remaining: c.config.MaxBodyBytes}
+	}
+	resp.Body = &countingBody{ReadCloser: resp.Body, res: res, start: tr.start, total: &r.stats.bytesRead}
+	body := resp.Body
 	defer func() {
-		if resp != nil && resp.Body != nil {
-			if err := resp.Body.Close(); err != nil {
-				c.config.ErrorHandler(url, err)
+		if body != nil {
+			if err := body.Close(); err != nil {
+				r.fail(res, newError(KindBodyRead, url, err))
 			}
 		}
+		c.config.Metrics.done(res)
 	}()
 
-	if err := c.config.ResponseHandler(url, resp); err != nil {
-		c.config.ErrorHandler(url, err)
+	if r.frontier != nil && r.frontier.follows(t.depth) {
+		if err := c.followLinks(r, t, resp); err != nil {
+			r.fail(res, err)
+			return false
+		}
+	}
+
+	if err := r.handle(res); err != nil {
+		r.fail(res, newError(KindHandler, url, err))
+	}
+	return false
+}
+
+// send is the innermost FetchFunc of the Middleware chain: it sends the request with the client.
+func (c *Crawler) send(f *Fetch) (*http.Response, error) {
+	resp, err := c.client.Do(f.Request)
+	if err != nil {
+		if cause := timeoutCause(f.Request.Context()); cause != nil {
+			return nil, newError(KindTimeout, f.URL, cause)
+		}
+		return nil, classifyError(f.URL, err)
+	}
+	return resp, nil
+}
+
+// retry consults the RetryPolicy and requeues the task if it asks for another attempt.
+// It returns true if the task was requeued, in which case resp has been closed.
+func (c *Crawler) retry(ctx context.Context, sched *scheduler, t *task, resp *http.Response, err error) bool {
+	if c.config.RetryPolicy == nil || ctx.Err() != nil {
+		return false
+	}
+
+	retry, delay := c.config.RetryPolicy(t.url, t.attempt, resp, err)
+	if !retry {
+		return false
+	}
+
+	if resp != nil && resp.Body != nil {
+		// Drain a bit so the connection can be reused
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		_ = resp.Body.Close()
+	}
+
+	t.attempt++
+	sched.retry(t, delay)
+	return true
+}
+
+// followLinks extracts links from an HTML response and queues the ones the frontier admits.
+// The body is buffered and replaced, so the ResponseHandler can still read it.
+func (c *Crawler) followLinks(r *run, t *task, resp *http.Response) error {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return newError(KindBodyRead, t.url, err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	// A seed that redirects, e.g. from example.com to www.example.com, is scoped by
+	// where it ended up. Deeper pages keep the origin, so a redirect cannot widen the scope.
+	origin := t.origin
+	if t.depth == 0 {
+		if host := strings.ToLower(resp.Request.URL.Hostname()); host != "" {
+			origin = host
+		}
 	}
+
+	for _, link := range extractLinks(resp.Request.URL, bytes.NewReader(body)) {
+		if !r.frontier.admit(link, origin) {
+			continue
+		}
+		if j := c.config.Journal; j != nil {
+			if j.isDone(link.String()) {
+				continue
+			}
+			if err := j.markPending(link.String(), t.depth+1); err != nil {
+				return err
+			}
+		}
+		r.sched.add(&task{
+			url:     link.String(),
+			attempt: 1,
+			depth:   t.depth + 1,
+			origin:  origin,
+			meta:    t.meta,
+		})
+	}
+	return nil
+}
+
+type metaKey struct{}
+
+// withMeta returns a context carrying the metadata of an item.
+func withMeta(ctx context.Context, meta any) context.Context {
+	if meta == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, metaKey{}, meta)
+}
+
+// MetaFromContext returns the metadata of the item being crawled, or nil. It works with
+// the context passed to the RequestBuilder and with the context of the request of a
+// response passed to the ResponseHandler, resp.Request.Context().
+func MetaFromContext(ctx context.Context) any {
+	return ctx.Value(metaKey{})
 }
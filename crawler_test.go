@@ -2,6 +2,7 @@ package crawl
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -267,8 +269,7 @@ func TestResponseBodySaver(t *testing.T) {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
-	expectedFilename := "example.com"
-	expectedPath := filepath.Join(tmpDir, expectedFilename)
+	expectedPath := filepath.Join(tmpDir, "example.com", "test")
 
 	content, err := os.ReadFile(expectedPath)
 	if err != nil {
@@ -421,3 +422,70 @@ func TestSecChUaGeneration(t *testing.T) {
 		})
 	}
 }
+
+func TestRunItems(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Header().Set("Content-Type", "text/html")
+			io.WriteString(w, `<a href="/linked">link</a>`) //nolint:errcheck
+		}
+	}))
+	defer server.Close()
+
+	type customer struct{ id int }
+
+	var mu sync.Mutex
+	built := map[any]int{}
+	handled := map[string][]any{}
+	failed := map[string]any{}
+	crawler := New(ctx, Config{
+		WorkerCount: 2,
+		UserAgent:   "test",
+		FollowLinks: &LinkPolicy{MaxDepth: 1},
+		RequestBuilder: func(ctx context.Context, url string) (*http.Request, error) {
+			mu.Lock()
+			built[MetaFromContext(ctx)]++
+			mu.Unlock()
+			return DefaultRequestBuilder(ctx, url)
+		},
+		ResponseHandler: func(url string, resp *http.Response) error {
+			mu.Lock()
+			defer mu.Unlock()
+			handled[resp.Request.URL.Path] = append(handled[resp.Request.URL.Path], MetaFromContext(resp.Request.Context()))
+			return nil
+		},
+		ErrorHandler: func(url string, err error) {
+			var e *Error
+			if errors.As(err, &e) {
+				mu.Lock()
+				failed[url] = e.Meta
+				mu.Unlock()
+			}
+		},
+	})
+
+	// The same URL twice, with different metadata
+	items := func(yield func(Item) bool) {
+		_ = yield(Item{URL: server.URL + "/", Meta: customer{1}}) &&
+			yield(Item{URL: server.URL + "/", Meta: customer{2}}) &&
+			yield(Item{URL: "://invalid", Meta: customer{3}})
+	}
+	if err := crawler.RunItems(ctx, items); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if metas := handled["/"]; len(metas) != 2 || metas[0] == metas[1] {
+		t.Errorf("expected both customers for the same URL, got %v", metas)
+	}
+	if metas := handled["/linked"]; len(metas) == 0 || (metas[0] != customer{1} && metas[0] != customer{2}) {
+		t.Errorf("expected the followed link to inherit metadata, got %v", metas)
+	}
+	if built[customer{1}] == 0 || built[customer{2}] == 0 {
+		t.Errorf("expected the RequestBuilder to see metadata, got %v", built)
+	}
+	if failed["://invalid"] != (customer{3}) {
+		t.Errorf("expected the error to carry metadata, got %v", failed)
+	}
+}
@@ -0,0 +1,110 @@
+package crawl
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// maxZstdWindow is the largest zstd window decoded, as required for HTTP by RFC 9659 and
+// enforced by browsers. It bounds the memory a response can make the decoder allocate.
+const maxZstdWindow = 8 << 20
+
+// decoders create a decoding reader per content coding.
+var decoders = map[string]func(io.Reader) (io.Reader, error){
+	"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	"deflate": func(r io.Reader) (io.Reader, error) {
+		// "deflate" is meant to be zlib, but some servers send a raw deflate stream
+		br := bufio.NewReader(r)
+		header, err := br.Peek(2)
+		if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	},
+	"br": func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+	"zstd": func(r io.Reader) (io.Reader, error) {
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxWindow(maxZstdWindow), zstd.WithDecoderMaxMemory(maxZstdWindow))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	},
+}
+
+// supportedEncodings returns the codings of an Accept-Encoding value that decodeBody can
+// decode, in their original order.
+func supportedEncodings(acceptEncoding string) string {
+	var codings []string
+	for _, c := range strings.Split(acceptEncoding, ",") {
+		c = strings.TrimSpace(c)
+		if _, ok := decoders[strings.ToLower(strings.TrimSpace(strings.SplitN(c, ";", 2)[0]))]; ok {
+			codings = append(codings, c)
+		}
+	}
+	return strings.Join(codings, ", ")
+}
+
+// decodeBody replaces a compressed response body by its decoded content, and removes the
+// Content-Encoding and Content-Length headers, as net/http does. The original coding and
+// the number of compressed bytes read are recorded in res.
+func decodeBody(resp *http.Response, res *Result) {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	newReader, ok := decoders[encoding]
+	if !ok {
+		return
+	}
+
+	res.ContentEncoding = encoding
+	resp.Body = &decodedBody{body: resp.Body, newReader: newReader, res: res}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+}
+
+// decodedBody decodes a response body. The decoder is created on the first
+// Read, so reading the compression header does not block the request.
+type decodedBody struct {
+	body      io.ReadCloser
+	newReader func(io.Reader) (io.Reader, error)
+	res       *Result
+	r         io.Reader
+	err       error
+}
+
+func (d *decodedBody) Read(p []byte) (int, error) {
+	if d.r == nil && d.err == nil {
+		d.r, d.err = d.newReader(compressedReader{d})
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return d.r.Read(p)
+}
+
+func (d *decodedBody) Close() error {
+	if c, ok := d.r.(io.Closer); ok {
+		c.Close() //nolint:errcheck
+	}
+	return d.body.Close()
+}
+
+// compressedReader reads the compressed body and counts its bytes.
+type compressedReader struct {
+	d *decodedBody
+}
+
+func (c compressedReader) Read(p []byte) (int, error) {
+	n, err := c.d.body.Read(p)
+	c.d.res.CompressedBytes += int64(n)
+	return n, err
+}
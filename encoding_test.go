@@ -0,0 +1,159 @@
+package crawl
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestSupportedEncodings(t *testing.T) {
+	tests := map[string]string{
+		"gzip, deflate, br, zstd": "gzip, deflate, br, zstd",
+		"compress, br;q=0.8":      "br;q=0.8",
+		"compress":                "",
+	}
+	for in, expected := range tests {
+		if got := supportedEncodings(in); got != expected {
+			t.Errorf("supportedEncodings(%q): expected %q, got %q", in, expected, got)
+		}
+	}
+}
+
+func TestDecodeBody(t *testing.T) {
+	ctx := context.Background()
+	content := strings.Repeat("<p>hello world</p>", 100)
+
+	encode := func(newWriter func(io.Writer) io.WriteCloser) []byte {
+		var buf bytes.Buffer
+		w := newWriter(&buf)
+		io.WriteString(w, content) //nolint:errcheck
+		w.Close()
+		return buf.Bytes()
+	}
+	encoded := map[string][]byte{
+		"gzip":    encode(func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }),
+		"deflate": encode(func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }),
+		"raw":     encode(func(w io.Writer) io.WriteCloser { fw, _ := flate.NewWriter(w, flate.DefaultCompression); return fw }),
+		"br":      encode(func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) }),
+		"zstd":    encode(func(w io.Writer) io.WriteCloser { zw, _ := zstd.NewWriter(w); return zw }),
+	}
+
+	var acceptEncoding string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		switch name {
+		case "identity":
+			io.WriteString(w, content) //nolint:errcheck
+		case "raw":
+			w.Header().Set("Content-Encoding", "deflate")
+			w.Write(encoded[name]) //nolint:errcheck
+		default:
+			w.Header().Set("Content-Encoding", name)
+			w.Write(encoded[name]) //nolint:errcheck
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path     string
+		encoding string
+	}{
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"raw", "deflate"},
+		{"br", "br"},
+		{"zstd", "zstd"},
+		{"identity", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			var res *Result
+			var body string
+			crawler := New(ctx, Config{
+				HeaderProfiles: []*HeaderProfile{ChromeWindowsProfile("")},
+				ResultHandler: func(r *Result) error {
+					data, err := io.ReadAll(r.Response.Body)
+					res, body = r, string(data)
+					return err
+				},
+				ErrorHandler: func(url string, err error) { t.Errorf("unexpected error for %s: %v", url, err) },
+			})
+			if err := crawler.Run(ctx, func(yield func(string) bool) { yield(server.URL + "/" + tt.path) }); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if acceptEncoding != "gzip, deflate, br, zstd" {
+				t.Errorf("expected the Accept-Encoding of Chrome, got %q", acceptEncoding)
+			}
+			if body != content {
+				t.Errorf("expected decoded body, got %q", body)
+			}
+			if res.ContentEncoding != tt.encoding {
+				t.Errorf("expected encoding %q, got %q", tt.encoding, res.ContentEncoding)
+			}
+			if tt.encoding != "" && res.CompressedBytes != int64(len(encoded[tt.path])) {
+				t.Errorf("expected %d compressed bytes, got %d", len(encoded[tt.path]), res.CompressedBytes)
+			}
+			if res.Response.Header.Get("Content-Encoding") != "" {
+				t.Error("expected Content-Encoding to be removed")
+			}
+		})
+	}
+
+	t.Run("requested by RequestBuilder", func(t *testing.T) {
+		var body []byte
+		crawler := New(ctx, Config{
+			UserAgent: "test",
+			RequestBuilder: func(ctx context.Context, url string) (*http.Request, error) {
+				req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+				if err == nil {
+					req.Header.Set("Accept-Encoding", "br")
+				}
+				return req, err
+			},
+			ResultHandler: func(r *Result) error {
+				var err error
+				body, err = io.ReadAll(r.Response.Body)
+				return err
+			},
+		})
+		if err := crawler.Run(ctx, func(yield func(string) bool) { yield(server.URL + "/br") }); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !bytes.Equal(body, encoded["br"]) {
+			t.Error("expected the body to be left encoded")
+		}
+	})
+}
+
+func TestDecodeZstdWindow(t *testing.T) {
+	// A frame with the given window size and a single raw block "x"
+	frame := func(windowLog byte) []byte {
+		return []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, (windowLog - 10) << 3, 0x09, 0x00, 0x00, 'x'}
+	}
+
+	for windowLog, ok := range map[byte]bool{23: true, 25: false} {
+		r, err := decoders["zstd"](bytes.NewReader(frame(windowLog)))
+		var data []byte
+		if err == nil {
+			data, err = io.ReadAll(r)
+		}
+		if ok && (err != nil || string(data) != "x") {
+			t.Errorf("window 1<<%d: expected x, got %q and %v", windowLog, data, err)
+		}
+		if !ok && err == nil {
+			t.Errorf("window 1<<%d: expected the window to be rejected", windowLog)
+		}
+	}
+}
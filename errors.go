@@ -0,0 +1,173 @@
+package crawl
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ErrorKind classifies the errors passed to the ErrorHandler.
+type ErrorKind int
+
+const (
+	// KindUnknown is an error that could not be classified, e.g. a cancelled request.
+	KindUnknown ErrorKind = iota
+	// KindDNS is a failed host name lookup, e.g. NXDOMAIN.
+	KindDNS
+	// KindConnect is a failed or broken connection, e.g. refused or reset.
+	KindConnect
+	// KindTLS is a failed TLS handshake or certificate verification.
+	KindTLS
+	// KindTimeout is a request that did not complete in time.
+	KindTimeout
+	// KindRedirect is a redirect that was refused by the RedirectionPolicy.
+	KindRedirect
+	// KindRequestBuild is an error returned by the RequestBuilder.
+	KindRequestBuild
+	// KindHandler is an error returned by the ResponseHandler or ResultHandler.
+	KindHandler
+	// KindBodyRead is an error reading or closing the response body.
+	KindBodyRead
+	// KindPolicy is a URL that was skipped by policy, e.g. disallowed by robots.txt.
+	KindPolicy
+	// KindMiddleware is an error returned by a Middleware.
+	KindMiddleware
+)
+
+var kindNames = map[ErrorKind]string{
+	KindUnknown:      "unknown",
+	KindDNS:          "dns",
+	KindConnect:      "connect",
+	KindTLS:          "tls",
+	KindTimeout:      "timeout",
+	KindRedirect:     "redirect",
+	KindRequestBuild: "request build",
+	KindHandler:      "handler",
+	KindBodyRead:     "body read",
+	KindPolicy:       "policy",
+	KindMiddleware:   "middleware",
+}
+
+// String returns the name of the kind, e.g. "dns".
+func (k ErrorKind) String() string {
+	if name, ok := kindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
+
+// Error is an error crawling a URL. The cause is reachable with errors.As and errors.Is.
+// Errors that do not concern a single request, such as journal write failures, are
+// passed to the ErrorHandler as is.
+type Error struct {
+	Kind ErrorKind
+	URL  string
+	Err  error
+
+	// Meta is the metadata of the Item whose URL failed.
+	Meta any
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s error: %v", e.Kind, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// KindOf returns the kind of err, or KindUnknown if it is not an *Error.
+func KindOf(err error) ErrorKind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return KindUnknown
+}
+
+// IsDNS reports whether err is a failed host name lookup.
+func IsDNS(err error) bool { return KindOf(err) == KindDNS }
+
+// IsConnect reports whether err is a failed or broken connection.
+func IsConnect(err error) bool { return KindOf(err) == KindConnect }
+
+// IsTLS reports whether err is a failed TLS handshake or certificate verification.
+func IsTLS(err error) bool { return KindOf(err) == KindTLS }
+
+// IsTimeout reports whether err is a request that did not complete in time.
+func IsTimeout(err error) bool { return KindOf(err) == KindTimeout }
+
+// IsRedirect reports whether err is a redirect refused by the RedirectionPolicy.
+func IsRedirect(err error) bool { return KindOf(err) == KindRedirect }
+
+// IsPolicy reports whether err is a URL that was skipped by policy.
+func IsPolicy(err error) bool { return KindOf(err) == KindPolicy }
+
+// IsNetwork reports whether err is a DNS, connect, TLS or timeout error,
+// the kinds that retry logic is usually interested in.
+func IsNetwork(err error) bool {
+	switch KindOf(err) {
+	case KindDNS, KindConnect, KindTLS, KindTimeout:
+		return true
+	}
+	return false
+}
+
+// newError wraps err in an *Error of the given kind, unless it already is one.
+func newError(kind ErrorKind, url string, err error) error {
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	return &Error{Kind: kind, URL: url, Err: err}
+}
+
+// redirectError marks an error returned by the RedirectionPolicy.
+type redirectError struct {
+	err error
+}
+
+func (e *redirectError) Error() string { return e.err.Error() }
+func (e *redirectError) Unwrap() error { return e.err }
+
+// classifyError wraps an error returned by the HTTP client in an *Error.
+func classifyError(url string, err error) error {
+	return newError(classify(err), url, err)
+}
+
+// classify determines the kind of an error returned by the HTTP client.
+func classify(err error) ErrorKind {
+	var (
+		redirectErr  *redirectError
+		dnsErr       *net.DNSError
+		certErr      *tls.CertificateVerificationError
+		recordErr    tls.RecordHeaderError
+		hostnameErr  x509.HostnameError
+		authorityErr x509.UnknownAuthorityError
+		invalidErr   x509.CertificateInvalidError
+		opErr        *net.OpError
+		netErr       net.Error
+	)
+
+	switch {
+	case errors.As(err, &redirectErr):
+		return KindRedirect
+	case errors.As(err, &dnsErr):
+		return KindDNS
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &hostnameErr),
+		errors.As(err, &authorityErr), errors.As(err, &invalidErr):
+		return KindTLS
+	case errors.As(err, &opErr) && (opErr.Op == "remote error" || opErr.Op == "local error"):
+		// TLS alerts
+		return KindTLS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return KindTimeout
+	case errors.Is(err, context.Canceled):
+		return KindUnknown
+	default:
+		return KindConnect
+	}
+}
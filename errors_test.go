@@ -0,0 +1,114 @@
+package crawl
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	urlErr := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://example.com/", Err: err}
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		kind ErrorKind
+	}{
+		{"nxdomain", urlErr(&net.DNSError{Err: "no such host", Name: "example.com", IsNotFound: true}), KindDNS},
+		{"refused", urlErr(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", errors.New("connection refused"))}), KindConnect},
+		{"certificate", urlErr(x509.UnknownAuthorityError{}), KindTLS},
+		{"alert", urlErr(&net.OpError{Op: "remote error", Err: errors.New("tls: bad certificate")}), KindTLS},
+		{"deadline", urlErr(context.DeadlineExceeded), KindTimeout},
+		{"redirect", urlErr(&redirectError{errors.New("too many redirects")}), KindRedirect},
+		{"canceled", urlErr(context.Canceled), KindUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyError("https://example.com/", tt.err)
+			if KindOf(err) != tt.kind {
+				t.Errorf("expected %s, got %s", tt.kind, KindOf(err))
+			}
+			if !errors.Is(err, tt.err) {
+				t.Error("expected the cause to be reachable")
+			}
+		})
+	}
+
+	if !IsNetwork(classifyError("", urlErr(context.DeadlineExceeded))) || IsNetwork(newError(KindHandler, "", errors.New("x"))) {
+		t.Error("unexpected IsNetwork result")
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/redirect", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	// A port that refuses connections
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	refused := "http://" + ln.Addr().String() + "/"
+	ln.Close()
+
+	errHandler := errors.New("handler failed")
+	var mu sync.Mutex
+	kinds := map[string]ErrorKind{}
+	crawler := New(ctx, Config{
+		UserAgent: "test",
+		RequestBuilder: func(ctx context.Context, url string) (*http.Request, error) {
+			if url == "::invalid" {
+				return nil, errors.New("invalid URL")
+			}
+			return DefaultRequestBuilder(ctx, url)
+		},
+		ResponseHandler: func(url string, resp *http.Response) error { return errHandler },
+		RedirectionPolicy: func(req *http.Request, via []*http.Request) error {
+			return errors.New("redirects not allowed")
+		},
+		ErrorHandler: func(url string, err error) {
+			var e *Error
+			if !errors.As(err, &e) || e.URL != url {
+				t.Errorf("expected *Error for %s, got %v", url, err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			kinds[url] = KindOf(err)
+		},
+	})
+
+	urls := map[string]ErrorKind{
+		server.URL + "/ok":       KindHandler,
+		server.URL + "/redirect": KindRedirect,
+		refused:                  KindConnect,
+		"::invalid":              KindRequestBuild,
+	}
+	gen := func(yield func(string) bool) {
+		for u := range urls {
+			if !yield(u) {
+				return
+			}
+		}
+	}
+	if err := crawler.Run(ctx, gen); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for u, expected := range urls {
+		if kinds[u] != expected {
+			t.Errorf("%s: expected %s, got %s", u, expected, kinds[u])
+		}
+	}
+}
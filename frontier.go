@@ -0,0 +1,211 @@
+package crawl
+
+import (
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/publicsuffix"
+)
+
+// LinkScope determines which discovered links are followed.
+type LinkScope int
+
+const (
+	// ScopeSameHost follows links to the same hostname as the seed URL, after redirects.
+	ScopeSameHost LinkScope = iota
+	// ScopeSameDomain follows links that share the public suffix plus one label
+	// with the seed URL after redirects, e.g. www.example.com and shop.example.com.
+	ScopeSameDomain
+	// ScopeRegexp follows links that match one of LinkPolicy.Allow.
+	ScopeRegexp
+)
+
+// LinkPolicy configures recursive crawling of links found in HTML responses.
+type LinkPolicy struct {
+	// MaxDepth is the maximum number of links followed from a seed URL. Default: 0 (no limit).
+	MaxDepth int
+
+	// MaxPagesPerHost limits the number of pages fetched per host, including seeds. Default: 0 (no limit).
+	MaxPagesPerHost int
+
+	// Scope determines which links are followed. Default: ScopeSameHost.
+	Scope LinkScope
+
+	// Allow lists the patterns a link must match when Scope is ScopeRegexp.
+	Allow []*regexp.Regexp
+}
+
+// frontier deduplicates discovered links and applies the LinkPolicy.
+type frontier struct {
+	policy LinkPolicy
+
+	mu    sync.Mutex
+	seen  map[string]struct{}
+	pages map[string]int
+}
+
+func newFrontier(policy LinkPolicy) *frontier {
+	return &frontier{
+		policy: policy,
+		seen:   make(map[string]struct{}),
+		pages:  make(map[string]int),
+	}
+}
+
+// seed records a URL from the generator. Seeds are always crawled,
+// but links pointing back to them are not.
+func (f *frontier) seed(rawURL string) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seen[normalizeURL(u)] = struct{}{}
+	f.pages[strings.ToLower(u.Hostname())]++
+}
+
+// follows reports whether links found on a page at the given depth should be extracted.
+func (f *frontier) follows(depth int) bool {
+	return f.policy.MaxDepth <= 0 || depth < f.policy.MaxDepth
+}
+
+// admit reports whether a link discovered from a page of the seed with
+// the given origin hostname should be crawled, and marks it as seen.
+func (f *frontier) admit(link *url.URL, origin string) bool {
+	if !f.inScope(link, origin) {
+		return false
+	}
+
+	key := normalizeURL(link)
+	host := strings.ToLower(link.Hostname())
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.seen[key]; ok {
+		return false
+	}
+	if f.policy.MaxPagesPerHost > 0 && f.pages[host] >= f.policy.MaxPagesPerHost {
+		return false
+	}
+	f.seen[key] = struct{}{}
+	f.pages[host]++
+	return true
+}
+
+// inScope applies the LinkScope to a link.
+func (f *frontier) inScope(link *url.URL, origin string) bool {
+	switch f.policy.Scope {
+	case ScopeSameDomain:
+		linkDomain, err := publicsuffix.EffectiveTLDPlusOne(link.Hostname())
+		if err != nil {
+			return false
+		}
+		originDomain, err := publicsuffix.EffectiveTLDPlusOne(origin)
+		if err != nil {
+			return false
+		}
+		return strings.EqualFold(linkDomain, originDomain)
+	case ScopeRegexp:
+		s := link.String()
+		for _, re := range f.policy.Allow {
+			if re.MatchString(s) {
+				return true
+			}
+		}
+		return false
+	default:
+		return strings.EqualFold(link.Hostname(), origin)
+	}
+}
+
+// normalizeURL returns a canonical form of u for deduplication:
+// lowercase scheme and host, no default port, no fragment and a non-empty path.
+func normalizeURL(u *url.URL) string {
+	n := *u
+	n.Scheme = strings.ToLower(n.Scheme)
+	n.Fragment = ""
+	n.RawFragment = ""
+	n.User = nil
+
+	host := strings.ToLower(n.Hostname())
+	port := n.Port()
+	if (n.Scheme == "http" && port == "80") || (n.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	n.Host = host
+
+	if n.Path == "" {
+		n.Path = "/"
+		n.RawPath = ""
+	}
+	return n.String()
+}
+
+// linkAttrs maps the elements that are followed to their URL attribute.
+var linkAttrs = map[string]string{
+	"a":      "href",
+	"link":   "href",
+	"script": "src",
+	"iframe": "src",
+}
+
+// extractLinks returns the absolute http(s) links found in an HTML document,
+// resolved against base or the document's <base href>.
+func extractLinks(base *url.URL, r io.Reader) []*url.URL {
+	var links []*url.URL
+	z := html.NewTokenizer(r)
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return links
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+
+		name, hasAttr := z.TagName()
+		if !hasAttr {
+			continue
+		}
+		tag := string(name)
+		want, ok := linkAttrs[tag]
+		if !ok && tag != "base" {
+			continue
+		}
+		if tag == "base" {
+			want = "href"
+		}
+
+		for {
+			key, val, more := z.TagAttr()
+			if string(key) == want {
+				ref, err := base.Parse(strings.TrimSpace(string(val)))
+				if err == nil && (ref.Scheme == "http" || ref.Scheme == "https") {
+					if tag == "base" {
+						base = ref
+					} else {
+						links = append(links, ref)
+					}
+				}
+				break
+			}
+			if !more {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package crawl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestExtractLinks(t *testing.T) {
+	base, _ := url.Parse("https://example.com/shop/index.html")
+	doc := `<html><head>
+<link rel="stylesheet" href="/style.css">
+<script src="app.js"></script>
+</head><body>
+<a href="https://other.com/page#top">other</a>
+<a href="mailto:info@example.com">mail</a>
+<iframe src="//cdn.example.net/frame"></iframe>
+<img src="/not-followed.png">
+</body></html>`
+
+	var got []string
+	for _, link := range extractLinks(base, strings.NewReader(doc)) {
+		got = append(got, link.String())
+	}
+
+	expected := []string{
+		"https://example.com/style.css",
+		"https://example.com/shop/app.js",
+		"https://other.com/page#top",
+		"https://cdn.example.net/frame",
+	}
+	if !slices.Equal(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	t.Run("base href", func(t *testing.T) {
+		doc := `<base href="https://static.example.com/v2/"><a href="page">x</a>`
+		links := extractLinks(base, strings.NewReader(doc))
+		if len(links) != 1 || links[0].String() != "https://static.example.com/v2/page" {
+			t.Errorf("expected link resolved against base href, got %v", links)
+		}
+	})
+}
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		in, expected string
+	}{
+		{"HTTPS://Example.COM", "https://example.com/"},
+		{"https://example.com:443/a#frag", "https://example.com/a"},
+		{"http://example.com:8080/a?b=c", "http://example.com:8080/a?b=c"},
+		{"http://user:pw@example.com:80/", "http://example.com/"},
+	}
+
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.in)
+		if got := normalizeURL(u); got != tt.expected {
+			t.Errorf("normalizeURL(%q) = %q, expected %q", tt.in, got, tt.expected)
+		}
+	}
+}
+
+func TestFrontierScope(t *testing.T) {
+	link := func(s string) *url.URL {
+		u, _ := url.Parse(s)
+		return u
+	}
+
+	t.Run("same host", func(t *testing.T) {
+		f := newFrontier(LinkPolicy{})
+		if !f.admit(link("https://example.com/a"), "example.com") {
+			t.Error("expected same host link to be admitted")
+		}
+		if f.admit(link("https://example.com/a#x"), "example.com") {
+			t.Error("expected duplicate link to be rejected")
+		}
+		if f.admit(link("https://www.example.com/"), "example.com") {
+			t.Error("expected other host to be rejected")
+		}
+	})
+
+	t.Run("same domain", func(t *testing.T) {
+		f := newFrontier(LinkPolicy{Scope: ScopeSameDomain})
+		if !f.admit(link("https://shop.example.co.uk/"), "www.example.co.uk") {
+			t.Error("expected same domain link to be admitted")
+		}
+		if f.admit(link("https://other.co.uk/"), "www.example.co.uk") {
+			t.Error("expected other domain to be rejected")
+		}
+	})
+
+	t.Run("regexp", func(t *testing.T) {
+		f := newFrontier(LinkPolicy{Scope: ScopeRegexp, Allow: []*regexp.Regexp{regexp.MustCompile(`/checkout`)}})
+		if !f.admit(link("https://any.com/checkout/cart"), "example.com") {
+			t.Error("expected matching link to be admitted")
+		}
+		if f.admit(link("https://any.com/about"), "example.com") {
+			t.Error("expected non-matching link to be rejected")
+		}
+	})
+
+	t.Run("max pages per host", func(t *testing.T) {
+		f := newFrontier(LinkPolicy{MaxPagesPerHost: 2})
+		f.seed("https://example.com/")
+		if !f.admit(link("https://example.com/a"), "example.com") {
+			t.Error("expected second page to be admitted")
+		}
+		if f.admit(link("https://example.com/b"), "example.com") {
+			t.Error("expected third page to be rejected")
+		}
+	})
+}
+
+func TestCrawlerFollowLinks(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<a href="/a">a</a><a href="/b">b</a><a href="https://elsewhere.invalid/">x</a>`)
+		case "/a":
+			fmt.Fprint(w, `<a href="/">home</a><a href="/a/deep">deep</a>`)
+		case "/a/deep":
+			fmt.Fprint(w, `<a href="/too-deep">too deep</a>`)
+		default:
+			fmt.Fprint(w, `leaf`)
+		}
+	}))
+	defer server.Close()
+
+	var mu sync.Mutex
+	var paths []string
+	var bodyLen int
+	crawler := New(ctx, Config{
+		WorkerCount: 3,
+		UserAgent:   "test",
+		FollowLinks: &LinkPolicy{MaxDepth: 2},
+		ResponseHandler: func(u string, resp *http.Response) error {
+			buf := make([]byte, 1024)
+			n, _ := resp.Body.Read(buf)
+			mu.Lock()
+			defer mu.Unlock()
+			paths = append(paths, resp.Request.URL.Path)
+			if resp.Request.URL.Path == "/" {
+				bodyLen = n
+			}
+			return nil
+		},
+	})
+
+	urls := func(yield func(string) bool) { yield(server.URL + "/") }
+	if err := crawler.Run(ctx, urls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	slices.Sort(paths)
+	expected := []string{"/", "/a", "/a/deep", "/b"}
+	if !slices.Equal(paths, expected) {
+		t.Errorf("expected %v, got %v", expected, paths)
+	}
+	if bodyLen == 0 {
+		t.Error("expected handler to still be able to read the body")
+	}
+}
+
+func TestCrawlerFollowLinksAfterRedirect(t *testing.T) {
+	ctx := context.Background()
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The seed is fetched via localhost and redirects to 127.0.0.1, like example.com to www.example.com
+		if strings.HasPrefix(r.Host, "localhost:") {
+			http.Redirect(w, r, server.URL+r.URL.Path, http.StatusMovedPermanently)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<a href="/a">a</a>`)
+		}
+	}))
+	defer server.Close()
+
+	var mu sync.Mutex
+	var paths []string
+	crawler := New(ctx, Config{
+		UserAgent:   "test",
+		FollowLinks: &LinkPolicy{MaxDepth: 1},
+		ResponseHandler: func(u string, resp *http.Response) error {
+			mu.Lock()
+			defer mu.Unlock()
+			paths = append(paths, resp.Request.URL.Path)
+			return nil
+		},
+	})
+
+	seed := strings.Replace(server.URL, "127.0.0.1", "localhost", 1) + "/"
+	urls := func(yield func(string) bool) { yield(seed) }
+	if err := crawler.Run(ctx, urls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	slices.Sort(paths)
+	expected := []string{"/", "/a"}
+	if !slices.Equal(paths, expected) {
+		t.Errorf("expected %v, got %v", expected, paths)
+	}
+}
@@ -2,4 +2,9 @@ module github.com/gwillem/crawl
 
 go 1.25.3
 
-require golang.org/x/net v0.47.0 // indirect
+require (
+	github.com/andybalholm/brotli v1.2.0
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/net v0.47.0
+	golang.org/x/text v0.31.0
+)
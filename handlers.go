@@ -4,13 +4,11 @@ import (
 	"bufio"
 	"context"
 	"fmt"
-	"io"
 	"iter"
 	"net/http"
-	"net/url"
 	"os"
-	"path/filepath"
 	"strings"
+	"time"
 
 	"golang.org/x/net/publicsuffix"
 )
@@ -65,39 +63,25 @@ func FileURLs(path string) iter.Seq[string] {
 	}
 }
 
-// ResponseBodySaver returns a ResponseHandler that saves response bodies to files.
-// Files are named by hostname: <dir>/<hostname>
+// ResponseBodySaver returns a ResponseHandler that saves response bodies to files,
+// mirroring the final URL: <dir>/<host>/<path>. See NewBodySaver for other layouts.
 // If dir is empty, uses "snapshot" as the default directory.
 func ResponseBodySaver(dir string) ResponseHandler {
 	if dir == "" {
 		dir = "snapshot"
 	}
 
-	if err := os.MkdirAll(dir, 0o755); err != nil {
+	saver, err := NewBodySaver(dir, SaverOptions{})
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error creating directory %s: %v\n", dir, err)
+		saver = &BodySaver{dir: dir, now: time.Now}
 	}
 
 	return func(urlStr string, resp *http.Response) error {
-		parsedURL, err := url.Parse(urlStr)
-		if err != nil {
-			return fmt.Errorf("failed to parse URL %s: %w", urlStr, err)
-		}
-
-		filename := parsedURL.Host
-		filepath := filepath.Join(dir, filename)
-
-		file, err := os.Create(filepath)
-		if err != nil {
-			return fmt.Errorf("failed to create file %s: %w", filepath, err)
+		if err := saver.Handle(urlStr, resp); err != nil {
+			return err
 		}
-		defer file.Close() //nolint:errcheck
-
-		_, err = io.Copy(file, resp.Body)
-		if err != nil {
-			return fmt.Errorf("failed to write response to %s: %w", filepath, err)
-		}
-
-		fmt.Printf("%d %s\n", resp.StatusCode, parsedURL.Host)
+		fmt.Printf("%d %s\n", resp.StatusCode, urlStr)
 		return nil
 	}
 }
@@ -0,0 +1,376 @@
+package crawl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// HARGrouping determines how HAR entries are split over files.
+type HARGrouping int
+
+const (
+	// HARPerRun writes all entries to a single file.
+	HARPerRun HARGrouping = iota
+	// HARPerHost writes one file per host of the crawled URL.
+	HARPerHost
+)
+
+// HAROptions configures a HARWriter.
+type HAROptions struct {
+	// Grouping determines how entries are split over files. Default: HARPerRun.
+	Grouping HARGrouping
+
+	// Bodies includes response bodies. Binary bodies are base64 encoded.
+	Bodies bool
+}
+
+// HARWriter records requests and responses in HAR 1.2 format, which can be
+// opened in Chrome devtools or any HAR viewer. Entries are appended to their file
+// as they are recorded, so memory does not grow with the crawl; the files are
+// complete HAR documents once the writer is closed. It is safe for concurrent use.
+type HARWriter struct {
+	dir     string
+	opts    HAROptions
+	started time.Time
+
+	mu    sync.Mutex
+	files map[string]struct{} // names of the files that were started
+}
+
+// NewHARWriter creates a HARWriter that writes files to dir.
+func NewHARWriter(dir string, opts HAROptions) (*HARWriter, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	return &HARWriter{
+		dir:     dir,
+		opts:    opts,
+		started: time.Now(),
+		files:   make(map[string]struct{}),
+	}, nil
+}
+
+// HandleResult is a ResultHandler that records the result, with an entry for every
+// redirect hop. The body is read completely, so the entry has its size and timings,
+// and replaced by an in-memory copy, so it can be passed on to another handler.
+func (h *HARWriter) HandleResult(res *Result) error {
+	resp := res.Response
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	var entries []harEntry
+	for _, hop := range res.Redirects {
+		req := hop.Request
+		if req == nil {
+			req = resp.Request
+		}
+		entries = append(entries, harEntry{
+			StartedDateTime: res.Started.Format(time.RFC3339Nano),
+			Request:         harRequestFor(req, hop.URL, resp.Proto),
+			Response: harResponse{
+				Status:      hop.StatusCode,
+				StatusText:  http.StatusText(hop.StatusCode),
+				HTTPVersion: resp.Proto,
+				Cookies:     []harNameValue{},
+				Headers:     harHeaders(hop.Header, ""),
+				Content:     harContent{MimeType: hop.Header.Get("Content-Type")},
+				RedirectURL: hop.Header.Get("Location"),
+				HeadersSize: -1,
+				BodySize:    -1,
+			},
+			Timings: harTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1},
+		})
+	}
+
+	// The body size is as transferred, the content size after decoding
+	bodySize := res.BytesRead
+	if res.ContentEncoding != "" {
+		bodySize = res.CompressedBytes
+	}
+	final := harEntry{
+		StartedDateTime: res.Started.Format(time.RFC3339Nano),
+		Time:            ms(max(res.Timings.Total, res.Timings.TTFB)),
+		Request:         harRequestFor(resp.Request, res.FinalURL, resp.Proto),
+		Response: harResponse{
+			Status:      resp.StatusCode,
+			StatusText:  strings.TrimSpace(strings.TrimPrefix(resp.Status, fmt.Sprint(resp.StatusCode))),
+			HTTPVersion: resp.Proto,
+			Cookies:     harCookies(resp.Cookies()),
+			Headers:     harHeaders(resp.Header, ""),
+			Content:     harContentFor(resp, body, h.opts.Bodies),
+			RedirectURL: resp.Header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    bodySize,
+		},
+		Timings: harTimingsFor(res.Timings),
+	}
+	if ip, _, err := net.SplitHostPort(res.RemoteAddr); err == nil {
+		final.ServerIPAddress = ip
+	}
+	entries = append(entries, final)
+
+	name := "crawl-" + h.started.UTC().Format("20060102150405") + ".har"
+	if h.opts.Grouping == HARPerHost {
+		name = harFileName(res.URL)
+	}
+	return h.append(name, entries)
+}
+
+// harIndent is the indentation of entries in the entries array of a HAR document.
+const harIndent = "      "
+
+// append writes entries to the file name, starting the document if it is new.
+func (h *HARWriter) append(name string, entries []harEntry) error {
+	var buf bytes.Buffer
+	for _, e := range entries {
+		data, err := json.MarshalIndent(e, harIndent, "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode HAR entry for %s: %w", e.Request.URL, err)
+		}
+		buf.WriteString(",\n" + harIndent)
+		buf.Write(data)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	path := filepath.Join(h.dir, name)
+	data := buf.Bytes()
+	flags := os.O_WRONLY | os.O_APPEND
+	if _, ok := h.files[name]; !ok {
+		data = append(harHead(), data[1:]...) // no comma before the first entry
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	_, err = file.Write(data)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	h.files[name] = struct{}{}
+	return nil
+}
+
+// harHead is the start of a HAR document, up to its entries.
+func harHead() []byte {
+	creator, _ := json.Marshal(harCreator{Name: "github.com/gwillem/crawl", Version: "1.0"})
+	return fmt.Appendf(nil, "{\n  \"log\": {\n    \"version\": \"1.2\",\n    \"creator\": %s,\n    \"pages\": [],\n    \"entries\": [", creator)
+}
+
+// Close completes the files that were written to.
+func (h *HARWriter) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for name := range h.files {
+		path := filepath.Join(h.dir, name)
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		_, err = file.WriteString("\n    ]\n  }\n}\n")
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+	h.files = make(map[string]struct{})
+	return nil
+}
+
+// harFileName returns the file name for the host of rawURL.
+func harFileName(rawURL string) string {
+	host := "unknown"
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	return strings.NewReplacer(":", "_", "/", "_").Replace(host) + ".har"
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	SSL     float64 `json:"ssl"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// ms converts a duration to fractional milliseconds.
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// harRequestFor describes req as sent to rawURL.
+func harRequestFor(req *http.Request, rawURL, proto string) harRequest {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		u = req.URL
+	}
+
+	query := []harNameValue{}
+	values := u.Query()
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		for _, v := range values[name] {
+			query = append(query, harNameValue{name, v})
+		}
+	}
+
+	bodySize := req.ContentLength
+	if bodySize <= 0 {
+		bodySize = 0
+	}
+
+	return harRequest{
+		Method:      req.Method,
+		URL:         u.String(),
+		HTTPVersion: proto,
+		Cookies:     harCookies(req.Cookies()),
+		Headers:     harHeaders(req.Header, u.Host),
+		QueryString: query,
+		HeadersSize: -1,
+		BodySize:    bodySize,
+	}
+}
+
+// harHeaders converts headers to HAR name/value pairs, with Host first if set.
+func harHeaders(header http.Header, host string) []harNameValue {
+	headers := []harNameValue{}
+	if host != "" {
+		headers = append(headers, harNameValue{"Host", host})
+	}
+	for _, name := range slices.Sorted(maps.Keys(header)) {
+		for _, v := range header[name] {
+			headers = append(headers, harNameValue{name, v})
+		}
+	}
+	return headers
+}
+
+func harCookies(cookies []*http.Cookie) []harNameValue {
+	list := []harNameValue{}
+	for _, c := range cookies {
+		list = append(list, harNameValue{c.Name, c.Value})
+	}
+	return list
+}
+
+// harContentFor describes the response body, as text if it is textual UTF-8 and base64 otherwise.
+func harContentFor(resp *http.Response, body []byte, withBody bool) harContent {
+	content := harContent{
+		Size:     int64(len(body)),
+		MimeType: resp.Header.Get("Content-Type"),
+	}
+	if !withBody {
+		return content
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(content.MimeType)
+	textual := strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "json") ||
+		strings.HasSuffix(mediaType, "xml") ||
+		strings.HasSuffix(mediaType, "javascript")
+	if textual && utf8.Valid(body) {
+		content.Text = string(body)
+	} else if len(body) > 0 {
+		content.Text = base64.StdEncoding.EncodeToString(body)
+		content.Encoding = "base64"
+	}
+	return content
+}
+
+// harTimingsFor converts phase timings to HAR timings. Connect includes SSL, as per the spec.
+func harTimingsFor(t Timings) harTimings {
+	timings := harTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1}
+	if t.DNS > 0 {
+		timings.DNS = ms(t.DNS)
+	}
+	if t.Connect > 0 {
+		timings.Connect = ms(t.Connect + t.TLS)
+	}
+	if t.TLS > 0 {
+		timings.SSL = ms(t.TLS)
+	}
+	timings.Wait = ms(max(t.TTFB-t.DNS-t.Connect-t.TLS, 0))
+	if t.Total > t.TTFB {
+		timings.Receive = ms(t.Total - t.TTFB)
+	}
+	return timings
+}
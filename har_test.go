@@ -0,0 +1,198 @@
+package crawl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// harDocument is a HAR file as written by HARWriter, for decoding in tests.
+type harDocument struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Pages   []struct{} `json:"pages"`
+	Entries []harEntry `json:"entries"`
+}
+
+func TestHARWriter(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		case "/logo.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte{0x89, 'P', 'N', 'G', 0xff}) //nolint:errcheck
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>hi</html>")) //nolint:errcheck
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	har, err := NewHARWriter(dir, HAROptions{Grouping: HARPerHost, Bodies: true})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	crawler := New(ctx, Config{
+		WorkerCount:   1,
+		UserAgent:     "test",
+		ResultHandler: har.HandleResult,
+	})
+
+	urls := func(yield func(string) bool) {
+		for _, path := range []string{"/old", "/logo.png"} {
+			if !yield(server.URL + path) {
+				return
+			}
+		}
+	}
+	if err := crawler.Run(ctx, urls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := har.Close(); err != nil {
+		t.Fatalf("expected no error closing, got %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.har"))
+	if len(files) != 1 || filepath.Base(files[0]) != harFileName(server.URL) {
+		t.Fatalf("expected a single file per host, got %v", files)
+	}
+
+	data, _ := os.ReadFile(files[0])
+	var doc harDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("expected valid JSON, got %v", err)
+	}
+	if doc.Log.Version != "1.2" {
+		t.Errorf("expected HAR 1.2, got %q", doc.Log.Version)
+	}
+
+	entries := doc.Log.Entries
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries (redirect, page, image), got %d", len(entries))
+	}
+
+	byURL := map[string]harEntry{}
+	for _, e := range entries {
+		byURL[e.Request.URL] = e
+	}
+
+	redirect := byURL[server.URL+"/old"]
+	if redirect.Response.Status != http.StatusMovedPermanently || redirect.Response.RedirectURL != "/new" {
+		t.Errorf("unexpected redirect entry: %+v", redirect.Response)
+	}
+
+	page := byURL[server.URL+"/new"]
+	if page.Response.Content.Text != "<html>hi</html>" || page.Response.Content.Encoding != "" {
+		t.Errorf("expected text body, got %+v", page.Response.Content)
+	}
+	if page.ServerIPAddress != "127.0.0.1" {
+		t.Errorf("expected server IP 127.0.0.1, got %q", page.ServerIPAddress)
+	}
+	if page.Timings.Wait < 0 || page.Timings.Receive < 0 {
+		t.Errorf("expected non-negative timings, got %+v", page.Timings)
+	}
+
+	var userAgent string
+	for _, h := range page.Request.Headers {
+		if h.Name == "User-Agent" {
+			userAgent = h.Value
+		}
+	}
+	if userAgent != "test" {
+		t.Errorf("expected User-Agent request header, got %q", userAgent)
+	}
+
+	image := byURL[server.URL+"/logo.png"]
+	if image.Response.Content.Encoding != "base64" {
+		t.Errorf("expected base64 body for image, got %+v", image.Response.Content)
+	}
+	if decoded, _ := base64.StdEncoding.DecodeString(image.Response.Content.Text); len(decoded) != 5 {
+		t.Errorf("expected 5 decoded bytes, got %d", len(decoded))
+	}
+}
+
+func TestHARWriterStreaming(t *testing.T) {
+	ctx := context.Background()
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte(strings.Repeat("hello ", 100))) //nolint:errcheck
+	zw.Close()                                      //nolint:errcheck
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/form":
+			http.Redirect(w, r, "/done", http.StatusSeeOther)
+		case "/done":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(compressed.Bytes()) //nolint:errcheck
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	har, _ := NewHARWriter(dir, HAROptions{})
+	crawler := New(ctx, Config{
+		UserAgent: "test",
+		RequestBuilder: func(ctx context.Context, url string) (*http.Request, error) {
+			return http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader("a=1"))
+		},
+		ResultHandler: har.HandleResult,
+	})
+	if err := crawler.Run(ctx, func(yield func(string) bool) { yield(server.URL + "/form") }); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Entries are on disk before Close
+	files, _ := filepath.Glob(filepath.Join(dir, "*.har"))
+	if len(files) != 1 {
+		t.Fatalf("expected a single file, got %v", files)
+	}
+	if data, _ := os.ReadFile(files[0]); !strings.Contains(string(data), server.URL+"/done") {
+		t.Errorf("expected the entries to be written before Close, got %s", data)
+	}
+	if err := har.Close(); err != nil {
+		t.Fatalf("expected no error closing, got %v", err)
+	}
+
+	data, _ := os.ReadFile(files[0])
+	var doc harDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("expected valid JSON, got %v\n%s", err, data)
+	}
+	if len(doc.Log.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(doc.Log.Entries))
+	}
+
+	// The hop has its own request: a POST, followed by a GET after the 303
+	hop, final := doc.Log.Entries[0], doc.Log.Entries[1]
+	if hop.Request.Method != http.MethodPost || hop.Request.BodySize != 3 || final.Request.Method != http.MethodGet {
+		t.Errorf("expected POST then GET, got %s (%d bytes) and %s", hop.Request.Method, hop.Request.BodySize, final.Request.Method)
+	}
+
+	// Without bodies, the sizes and timings are still recorded
+	if final.Response.BodySize != int64(compressed.Len()) || final.Response.Content.Size != 600 || final.Response.Content.Text != "" {
+		t.Errorf("expected %d bytes transferred and 600 decoded without text, got %d and %+v",
+			compressed.Len(), final.Response.BodySize, final.Response.Content)
+	}
+	if final.Time <= 0 || final.Timings.Receive < 0 {
+		t.Errorf("expected timings, got %v %+v", final.Time, final.Timings)
+	}
+}
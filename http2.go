@@ -0,0 +1,264 @@
+package crawl
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// Stream of the single request on an ordered HTTP/2 connection.
+const http2StreamID = 1
+
+// Headers that are specific to HTTP/1.1 connections and not allowed in HTTP/2.
+var http2ConnectionHeaders = map[string]bool{
+	"Connection":        true,
+	"Host":              true,
+	"Keep-Alive":        true,
+	"Proxy-Connection":  true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+// roundTripHTTP2 sends req on a new HTTP/2 connection, with the connection preface and
+// pseudo-header order of the profile, and reads the response headers. The body is read
+// from the connection as it is consumed. release is called when the body is closed.
+func roundTripHTTP2(conn net.Conn, req *http.Request, fields []HeaderField, body []byte, profile *HTTP2Profile, trace *httptrace.ClientTrace, release func() error) (*http.Response, error) {
+	w := bufio.NewWriter(conn)
+	fr := http2.NewFramer(w, bufio.NewReader(conn))
+	fr.ReadMetaHeaders = hpack.NewDecoder(65536, nil)
+
+	w.WriteString(http2.ClientPreface) //nolint:errcheck
+	settings := make([]http2.Setting, len(profile.Settings))
+	for i, s := range profile.Settings {
+		settings[i] = http2.Setting{ID: http2.SettingID(s.ID), Val: s.Val}
+	}
+	if err := fr.WriteSettings(settings...); err != nil {
+		return nil, fmt.Errorf("failed to write HTTP/2 settings: %w", err)
+	}
+	if profile.WindowUpdate > 0 {
+		if err := fr.WriteWindowUpdate(0, profile.WindowUpdate); err != nil {
+			return nil, fmt.Errorf("failed to write HTTP/2 window update: %w", err)
+		}
+	}
+
+	var block bytes.Buffer
+	enc := hpack.NewEncoder(&block)
+	for _, f := range http2RequestHeaders(req, fields, profile.PseudoHeaderOrder) {
+		enc.WriteField(hpack.HeaderField{Name: f.Name, Value: f.Value}) //nolint:errcheck
+	}
+
+	params := http2.HeadersFrameParam{
+		StreamID:      http2StreamID,
+		BlockFragment: block.Bytes(),
+		EndStream:     len(body) == 0,
+		EndHeaders:    true,
+	}
+	if profile.Weight > 0 {
+		params.Priority = http2.PriorityParam{Exclusive: true, Weight: uint8(profile.Weight - 1)}
+	}
+	if err := fr.WriteHeaders(params); err != nil {
+		return nil, fmt.Errorf("failed to write HTTP/2 headers: %w", err)
+	}
+	if trace.WroteHeaders != nil {
+		trace.WroteHeaders()
+	}
+	for len(body) > 0 {
+		n := min(len(body), 16384)
+		if err := fr.WriteData(http2StreamID, n == len(body), body[:n]); err != nil {
+			return nil, fmt.Errorf("failed to write HTTP/2 data: %w", err)
+		}
+		body = body[n:]
+	}
+	err := w.Flush()
+	if trace.WroteRequest != nil {
+		trace.WroteRequest(httptrace.WroteRequestInfo{Err: err})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write request: %w", err)
+	}
+
+	s := &http2Stream{fr: fr, w: w, release: release}
+	first := true
+	for {
+		f, err := s.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		if first {
+			first = false
+			if trace.GotFirstResponseByte != nil {
+				trace.GotFirstResponseByte()
+			}
+		}
+
+		switch f := f.(type) {
+		case *http2.MetaHeadersFrame:
+			status, err := strconv.Atoi(f.PseudoValue("status"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid HTTP/2 status %q", f.PseudoValue("status"))
+			}
+			// Skip interim responses such as 103 Early Hints
+			if status >= 100 && status < 200 {
+				continue
+			}
+
+			resp := &http.Response{
+				Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+				StatusCode: status,
+				Proto:      "HTTP/2.0",
+				ProtoMajor: 2,
+				Header:     make(http.Header),
+				Request:    req,
+			}
+			for _, hf := range f.RegularFields() {
+				resp.Header.Add(http.CanonicalHeaderKey(hf.Name), hf.Value)
+			}
+			resp.ContentLength = contentLength(resp.Header.Get("Content-Length"))
+			s.ended = f.StreamEnded()
+			resp.Body = s
+			return resp, nil
+		case *http2.DataFrame:
+			return nil, errors.New("HTTP/2 data received before headers")
+		}
+	}
+}
+
+// http2RequestHeaders returns the pseudo-headers in the given order, followed by the
+// regular headers in lowercase.
+func http2RequestHeaders(req *http.Request, fields []HeaderField, pseudoOrder []string) []HeaderField {
+	authority := req.Host
+	if authority == "" {
+		authority = req.URL.Host
+	}
+	pseudo := map[string]string{
+		":method":    req.Method,
+		":authority": authority,
+		":scheme":    req.URL.Scheme,
+		":path":      req.URL.RequestURI(),
+	}
+	if len(pseudoOrder) == 0 {
+		pseudoOrder = []string{":method", ":authority", ":scheme", ":path"}
+	}
+
+	var headers []HeaderField
+	for _, name := range pseudoOrder {
+		headers = append(headers, HeaderField{name, pseudo[name]})
+	}
+	for _, f := range fields {
+		if http2ConnectionHeaders[http.CanonicalHeaderKey(f.Name)] {
+			continue
+		}
+		headers = append(headers, HeaderField{strings.ToLower(f.Name), f.Value})
+	}
+	return headers
+}
+
+// http2Stream is the response body of the single stream on a connection.
+// It reads frames as the body is consumed and answers control frames.
+type http2Stream struct {
+	fr      *http2.Framer
+	w       *bufio.Writer
+	release func() error
+
+	buf   []byte
+	ended bool
+	err   error
+}
+
+// readFrame reads the next frame for the stream, handling connection control frames.
+func (s *http2Stream) readFrame() (http2.Frame, error) {
+	for {
+		f, err := s.fr.ReadFrame()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read HTTP/2 frame: %w", err)
+		}
+
+		switch f := f.(type) {
+		case *http2.SettingsFrame:
+			if !f.IsAck() {
+				if err := s.write(func() error { return s.fr.WriteSettingsAck() }); err != nil {
+					return nil, err
+				}
+			}
+		case *http2.PingFrame:
+			if !f.IsAck() {
+				if err := s.write(func() error { return s.fr.WritePing(true, f.Data) }); err != nil {
+					return nil, err
+				}
+			}
+		case *http2.GoAwayFrame:
+			if f.LastStreamID < http2StreamID || f.ErrCode != http2.ErrCodeNo {
+				return nil, fmt.Errorf("HTTP/2 connection closed by server: %v", f.ErrCode)
+			}
+		case *http2.RSTStreamFrame:
+			return nil, fmt.Errorf("HTTP/2 stream reset by server: %v", f.ErrCode)
+		case *http2.MetaHeadersFrame, *http2.DataFrame:
+			if f.Header().StreamID == http2StreamID {
+				return f, nil
+			}
+		}
+	}
+}
+
+// write writes and flushes a frame.
+func (s *http2Stream) write(frame func() error) error {
+	if err := frame(); err != nil {
+		return fmt.Errorf("failed to write HTTP/2 frame: %w", err)
+	}
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("failed to write HTTP/2 frame: %w", err)
+	}
+	return nil
+}
+
+func (s *http2Stream) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.ended {
+			return 0, io.EOF
+		}
+
+		f, err := s.readFrame()
+		if err != nil {
+			s.err = err
+			continue
+		}
+		switch f := f.(type) {
+		case *http2.DataFrame:
+			// Frame data is only valid until the next read
+			s.buf = append(s.buf, f.Data()...)
+			s.ended = f.StreamEnded()
+			if n := uint32(f.Length); n > 0 && !s.ended {
+				s.err = s.write(func() error {
+					if err := s.fr.WriteWindowUpdate(0, n); err != nil {
+						return err
+					}
+					return s.fr.WriteWindowUpdate(http2StreamID, n)
+				})
+			}
+		case *http2.MetaHeadersFrame:
+			// Trailers
+			s.ended = f.StreamEnded()
+		}
+	}
+
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *http2Stream) Close() error {
+	return s.release()
+}
@@ -0,0 +1,226 @@
+package crawl
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const minCompactLines = 10000
+
+// Journal records on disk which URLs are pending and which are done, so an
+// interrupted crawl can be resumed with Config.Resume. It is an append-only
+// log that is compacted once it holds more stale than live lines; a torn last
+// line after a crash is ignored. URLs are keyed by their normalized form.
+type Journal struct {
+	path string
+
+	mu       sync.Mutex
+	file     *os.File
+	done     map[string]struct{}
+	pending  map[string]journalEntry
+	appended int // lines written since the last compaction
+	minLines int
+}
+
+// journalEntry is a URL that was queued but not yet completed.
+type journalEntry struct {
+	url   string
+	depth int
+}
+
+// OpenJournal opens or creates the journal at path and loads its state.
+func OpenJournal(path string) (*Journal, error) {
+	j := &Journal{
+		path:     path,
+		done:     make(map[string]struct{}),
+		pending:  make(map[string]journalEntry),
+		minLines: minCompactLines,
+	}
+	size, err := j.load()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal %s: %w", path, err)
+	}
+	// Cut off a torn last line, so the next line is not appended to it
+	if err := file.Truncate(size); err != nil {
+		file.Close() //nolint:errcheck
+		return nil, fmt.Errorf("failed to truncate journal %s: %w", path, err)
+	}
+	j.file = file
+	return j, nil
+}
+
+// load replays the journal file into memory. It returns the size of the complete lines.
+func (j *Journal) load() (int64, error) {
+	data, err := os.ReadFile(j.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read journal %s: %w", j.path, err)
+	}
+
+	lines := strings.Split(string(data), "\n")
+	// The last element is empty for a complete file, or a torn line after a crash
+	for _, line := range lines[:len(lines)-1] {
+		fields := strings.SplitN(line, "\t", 3)
+		switch {
+		case fields[0] == "P" && len(fields) == 3:
+			depth, err := strconv.Atoi(fields[1])
+			if err != nil {
+				continue
+			}
+			key := journalKey(fields[2])
+			if _, ok := j.done[key]; !ok {
+				j.pending[key] = journalEntry{url: fields[2], depth: depth}
+			}
+		case fields[0] == "D" && len(fields) == 2:
+			j.done[fields[1]] = struct{}{}
+			delete(j.pending, fields[1])
+		}
+	}
+	j.appended = len(lines) - 1
+	return int64(len(data) - len(lines[len(lines)-1])), nil
+}
+
+// journalKey returns the normalized form of rawURL used as journal key.
+func journalKey(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return normalizeURL(u)
+}
+
+// isDone reports whether rawURL was completed.
+func (j *Journal) isDone(rawURL string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	_, ok := j.done[journalKey(rawURL)]
+	return ok
+}
+
+// inflight returns the URLs that were queued but not completed.
+func (j *Journal) inflight() []journalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entries := make([]journalEntry, 0, len(j.pending))
+	for _, e := range j.pending {
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// markPending records that rawURL was queued.
+func (j *Journal) markPending(rawURL string, depth int) error {
+	key := journalKey(rawURL)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.pending[key] = journalEntry{url: rawURL, depth: depth}
+	return j.append(fmt.Sprintf("P\t%d\t%s\n", depth, rawURL))
+}
+
+// markDone records that rawURL was completed.
+func (j *Journal) markDone(rawURL string) error {
+	key := journalKey(rawURL)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	delete(j.pending, key)
+	j.done[key] = struct{}{}
+	return j.append("D\t" + key + "\n")
+}
+
+// append writes a line and compacts the journal if it has grown enough. Must be called with mu held.
+func (j *Journal) append(line string) error {
+	if _, err := j.file.WriteString(line); err != nil {
+		return fmt.Errorf("failed to write journal %s: %w", j.path, err)
+	}
+	j.appended++
+
+	if j.appended > max(j.minLines, 2*(len(j.done)+len(j.pending))) {
+		return j.compact()
+	}
+	return nil
+}
+
+// compact atomically rewrites the journal with only the live state. Must be called with mu held.
+func (j *Journal) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), ".journal-*")
+	if err != nil {
+		return fmt.Errorf("failed to compact journal %s: %w", j.path, err)
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+
+	w := bufio.NewWriter(tmp)
+	for key := range j.done {
+		fmt.Fprintf(w, "D\t%s\n", key)
+	}
+	for _, e := range j.pending {
+		fmt.Fprintf(w, "P\t%d\t%s\n", e.depth, e.url)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close() //nolint:errcheck
+		return fmt.Errorf("failed to compact journal %s: %w", j.path, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close() //nolint:errcheck
+		return fmt.Errorf("failed to compact journal %s: %w", j.path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to compact journal %s: %w", j.path, err)
+	}
+
+	if err := os.Rename(tmp.Name(), j.path); err != nil {
+		return fmt.Errorf("failed to compact journal %s: %w", j.path, err)
+	}
+
+	file, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to reopen journal %s: %w", j.path, err)
+	}
+	j.file.Close() //nolint:errcheck
+	j.file = file
+	j.appended = len(j.done) + len(j.pending)
+	return nil
+}
+
+// reset forgets all state, for a crawl that is not resumed.
+func (j *Journal) reset() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.done = make(map[string]struct{})
+	j.pending = make(map[string]journalEntry)
+	if err := j.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to reset journal %s: %w", j.path, err)
+	}
+	j.appended = 0
+	return nil
+}
+
+// Close compacts and closes the journal.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.compact(); err != nil {
+		return err
+	}
+	return j.file.Close()
+}
@@ -0,0 +1,156 @@
+package crawl
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestJournalResume(t *testing.T) {
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requested = append(requested, r.URL.Path)
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "crawl.journal")
+	urls := func(yield func(string) bool) {
+		for _, p := range []string{"/a", "/b", "/c"} {
+			if !yield(server.URL + p) {
+				return
+			}
+		}
+	}
+	crawl := func(resume bool) []string {
+		journal, err := OpenJournal(path)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		defer journal.Close()
+
+		requested = nil
+		crawler := New(ctx, Config{
+			UserAgent:       "test",
+			Journal:         journal,
+			Resume:          resume,
+			ResponseHandler: func(string, *http.Response) error { return nil },
+		})
+		if err := crawler.Run(ctx, urls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		slices.Sort(requested)
+		return requested
+	}
+
+	t.Run("skips completed URLs", func(t *testing.T) {
+		if got := crawl(false); len(got) != 3 {
+			t.Fatalf("expected 3 requests, got %v", got)
+		}
+		if got := crawl(true); len(got) != 0 {
+			t.Errorf("expected no requests on resume, got %v", got)
+		}
+		if got := crawl(false); len(got) != 3 {
+			t.Errorf("expected 3 requests without resume, got %v", got)
+		}
+	})
+
+	t.Run("requeues in-flight URLs after a crash", func(t *testing.T) {
+		journal := strings.Join([]string{
+			"P\t0\t" + server.URL + "/a",
+			"P\t0\t" + server.URL + "/b",
+			"P\t1\t" + server.URL + "/linked",
+			"D\t" + server.URL + "/a",
+			"P\t0\t" + server.URL + "/c", // torn line without newline
+		}, "\n")
+		if err := os.WriteFile(path, []byte(journal), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		got := crawl(true)
+		expected := []string{"/b", "/c", "/linked"}
+		if !slices.Equal(got, expected) {
+			t.Errorf("expected %v, got %v", expected, got)
+		}
+	})
+
+}
+
+func TestJournalCrashTwice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "crawl.journal")
+	torn := "P\t0\thttps://a.example/\nP\t0\thttps://b.exa"
+	if err := os.WriteFile(path, []byte(torn), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Crash after each line, without the compaction of Close
+	crash := func(rawURL string) []string {
+		journal, err := OpenJournal(path)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		var urls []string
+		for _, e := range journal.inflight() {
+			urls = append(urls, e.url)
+		}
+		slices.Sort(urls)
+		if rawURL != "" {
+			if err := journal.markPending(rawURL, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+		journal.file.Close() //nolint:errcheck
+		return urls
+	}
+
+	crash("https://c.example/")
+	if got, expected := crash(""), []string{"https://a.example/", "https://c.example/"}; !slices.Equal(got, expected) {
+		t.Errorf("expected %v, got %q", expected, got)
+	}
+}
+
+func TestJournalCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "crawl.journal")
+	journal, err := OpenJournal(path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	journal.minLines = 10
+
+	for i := 0; i < 20; i++ {
+		u := "https://example.com/" + strings.Repeat("x", i%3)
+		journal.markPending(u, 0)
+		journal.markDone(u)
+	}
+	journal.markPending("https://example.com/pending", 2)
+
+	data, _ := os.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines > 20 {
+		t.Errorf("expected journal to be compacted, got %d lines", lines)
+	}
+	journal.Close()
+
+	reopened, err := OpenJournal(path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer reopened.Close()
+
+	if !reopened.isDone("https://EXAMPLE.com:443/xx#frag") {
+		t.Error("expected normalized URL to be done")
+	}
+	inflight := reopened.inflight()
+	if len(inflight) != 1 || inflight[0].url != "https://example.com/pending" || inflight[0].depth != 2 {
+		t.Errorf("expected one pending URL at depth 2, got %+v", inflight)
+	}
+}
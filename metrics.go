@@ -0,0 +1,242 @@
+package crawl
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds in seconds of the phase latency histograms.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metricPhases are the phases of Result.Timings, in the order they are exported.
+var metricPhases = []string{"dns", "connect", "tls", "ttfb", "total"}
+
+// Metrics collects crawler metrics and serves them in the Prometheus text exposition
+// format. Set it as Config.Metrics and mount it on a metrics endpoint:
+//
+//	metrics := crawl.NewMetrics()
+//	http.Handle("/metrics", metrics)
+//
+// A Metrics may be shared by several crawlers, which then add up.
+type Metrics struct {
+	mu        sync.Mutex
+	requests  map[string]int64    // by status class
+	errors    map[ErrorKind]int64 // by kind
+	inflight  map[int]int64       // by worker
+	phases    map[string]*histogram
+	received  int64
+	retries   int64
+	redirects int64
+	queues    map[*scheduler]struct{} // of the runs in progress
+}
+
+// NewMetrics returns an empty Metrics.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		requests: make(map[string]int64),
+		errors:   make(map[ErrorKind]int64),
+		inflight: make(map[int]int64),
+		phases:   make(map[string]*histogram),
+		queues:   make(map[*scheduler]struct{}),
+	}
+	for _, phase := range metricPhases {
+		m.phases[phase] = &histogram{counts: make([]int64, len(latencyBuckets))}
+	}
+	return m
+}
+
+// The hooks below are called by the crawler, and do nothing on a nil Metrics.
+
+// register adds the queue of a run to the queue depth until unregister is called.
+func (m *Metrics) register(s *scheduler) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queues[s] = struct{}{}
+}
+
+func (m *Metrics) unregister(s *scheduler) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.queues, s)
+}
+
+// working adjusts the number of requests in flight for a worker by delta.
+func (m *Metrics) working(worker int, delta int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inflight[worker] += delta
+}
+
+// attempt records an attempt at a URL, with its response or error, and the redirects it followed.
+func (m *Metrics) attempt(res *Result, resp *http.Response, err error) {
+	if m == nil {
+		return
+	}
+	class := "error"
+	if err == nil && resp != nil {
+		class = strconv.Itoa(resp.StatusCode/100) + "xx"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[class]++
+	m.redirects += int64(len(res.Redirects))
+}
+
+// retry records an attempt that was retried.
+func (m *Metrics) retry() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retries++
+}
+
+// failure records an error reported to the ErrorHandler.
+func (m *Metrics) failure(err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors[KindOf(err)]++
+}
+
+// done records the timings and body size of a response once its body is closed.
+// Phases that were skipped, such as DNS on a reused connection, are not observed.
+func (m *Metrics) done(res *Result) {
+	if m == nil {
+		return
+	}
+	t := res.Timings
+	durations := []time.Duration{t.DNS, t.Connect, t.TLS, t.TTFB, t.Total}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, phase := range metricPhases {
+		if durations[i] > 0 {
+			m.phases[phase].observe(durations[i].Seconds())
+		}
+	}
+	m.received += res.received
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	var buf bytes.Buffer
+	m.write(&buf)
+	w.Write(buf.Bytes()) //nolint:errcheck
+}
+
+func (m *Metrics) write(w *bytes.Buffer) {
+	m.mu.Lock()
+	queues := slices.Collect(maps.Keys(m.queues))
+	m.mu.Unlock()
+	var depth int
+	for _, s := range queues {
+		depth += s.depth()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metricHeader(w, "crawl_requests_total", "counter", `Attempts by response status class, or "error" without a response.`)
+	for _, class := range slices.Sorted(maps.Keys(m.requests)) {
+		metricSample(w, "crawl_requests_total", metricLabels("class", class), float64(m.requests[class]))
+	}
+
+	metricHeader(w, "crawl_errors_total", "counter", "Errors reported to the ErrorHandler by kind.")
+	for _, kind := range slices.Sorted(maps.Keys(m.errors)) {
+		metricSample(w, "crawl_errors_total", metricLabels("kind", kind.String()), float64(m.errors[kind]))
+	}
+
+	metricHeader(w, "crawl_in_flight_requests", "gauge", "Requests in flight by worker.")
+	for _, worker := range slices.Sorted(maps.Keys(m.inflight)) {
+		metricSample(w, "crawl_in_flight_requests", metricLabels("worker", strconv.Itoa(worker)), float64(m.inflight[worker]))
+	}
+
+	metricHeader(w, "crawl_queue_depth", "gauge", "URLs queued for the workers, including retries waiting for their delay.")
+	metricSample(w, "crawl_queue_depth", "", float64(depth))
+
+	metricHeader(w, "crawl_phase_duration_seconds", "histogram", "Duration of the phases of handled responses.")
+	for _, phase := range metricPhases {
+		h := m.phases[phase]
+		var cumulative int64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			metricSample(w, "crawl_phase_duration_seconds_bucket", metricLabels("phase", phase, "le", formatFloat(bound)), float64(cumulative))
+		}
+		metricSample(w, "crawl_phase_duration_seconds_bucket", metricLabels("phase", phase, "le", "+Inf"), float64(h.count))
+		metricSample(w, "crawl_phase_duration_seconds_sum", metricLabels("phase", phase), h.sum)
+		metricSample(w, "crawl_phase_duration_seconds_count", metricLabels("phase", phase), float64(h.count))
+	}
+
+	metricHeader(w, "crawl_received_bytes_total", "counter", "Response body bytes read from the transport, before decoding of the content encoding or charset.")
+	metricSample(w, "crawl_received_bytes_total", "", float64(m.received))
+
+	metricHeader(w, "crawl_retries_total", "counter", "Attempts that were retried.")
+	metricSample(w, "crawl_retries_total", "", float64(m.retries))
+
+	metricHeader(w, "crawl_redirects_total", "counter", "Redirects followed.")
+	metricSample(w, "crawl_redirects_total", "", float64(m.redirects))
+}
+
+// histogram counts observations in the latencyBuckets.
+type histogram struct {
+	counts []int64 // per bucket, not cumulative
+	count  int64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	if i, _ := slices.BinarySearch(latencyBuckets, v); i < len(latencyBuckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+}
+
+func metricHeader(w *bytes.Buffer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func metricSample(w *bytes.Buffer, name, labels string, v float64) {
+	fmt.Fprintf(w, "%s%s %s\n", name, labels, formatFloat(v))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricLabels formats name and value pairs as a label set.
+func metricLabels(pairs ...string) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, pairs[i], labelEscaper.Replace(pairs[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
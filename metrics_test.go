@@ -0,0 +1,178 @@
+package crawl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	var flaky atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			<-release
+		case "/redirect":
+			http.Redirect(w, r, "/ok", http.StatusFound)
+			return
+		case "/missing":
+			http.NotFound(w, r)
+			return
+		case "/flaky":
+			if flaky.Add(1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+		io.WriteString(w, "hello") //nolint:errcheck
+	}))
+	defer server.Close()
+
+	metrics := NewMetrics()
+	endpoint := httptest.NewServer(metrics)
+	defer endpoint.Close()
+	scrape := func() string {
+		resp, err := http.Get(endpoint.URL)
+		if err != nil {
+			t.Fatalf("failed to scrape metrics: %v", err)
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+			t.Errorf("unexpected content type %q", ct)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	crawler := New(ctx, Config{
+		WorkerCount: 1,
+		UserAgent:   "test",
+		Metrics:     metrics,
+		RetryPolicy: BackoffRetryPolicy(2, time.Millisecond, time.Millisecond),
+		ResultHandler: func(res *Result) error {
+			if strings.HasSuffix(res.URL, "/missing") {
+				return errors.New("missing")
+			}
+			_, err := io.Copy(io.Discard, res.Response.Body)
+			return err
+		},
+	})
+
+	urls := func(yield func(string) bool) {
+		for _, path := range []string{"/slow", "/ok", "/redirect", "/missing", "/flaky"} {
+			if !yield(server.URL + path) {
+				return
+			}
+		}
+	}
+	done := make(chan error)
+	go func() { done <- crawler.Run(ctx, urls) }()
+
+	// The single worker is stuck on /slow while the other URLs are queued
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		out := scrape()
+		if strings.Contains(out, `crawl_in_flight_requests{worker="0"} 1`+"\n") && strings.Contains(out, "crawl_queue_depth 4\n") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a busy worker and 4 queued URLs, got\n%s", out)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	out := scrape()
+	for _, line := range []string{
+		"# TYPE crawl_requests_total counter",
+		`crawl_requests_total{class="2xx"} 4`,
+		`crawl_requests_total{class="4xx"} 1`,
+		`crawl_requests_total{class="5xx"} 1`,
+		`crawl_errors_total{kind="handler"} 1`,
+		`crawl_in_flight_requests{worker="0"} 0`,
+		"crawl_queue_depth 0",
+		"# TYPE crawl_phase_duration_seconds histogram",
+		`crawl_phase_duration_seconds_bucket{phase="ttfb",le="+Inf"} 5`,
+		`crawl_phase_duration_seconds_count{phase="total"} 5`,
+		"crawl_received_bytes_total 20", // the handler does not read /missing
+		"crawl_retries_total 1",
+		"crawl_redirects_total 1",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("expected %q in\n%s", line, out)
+		}
+	}
+}
+
+func TestMetricsNil(t *testing.T) {
+	// The crawler calls the hooks without checking for nil
+	var m *Metrics
+	m.register(nil)
+	m.working(0, 1)
+	m.attempt(&Result{}, nil, errors.New("failed"))
+	m.retry()
+	m.failure(errors.New("failed"))
+	m.done(&Result{})
+	m.unregister(nil)
+}
+
+func TestMetricsReceivedBytes(t *testing.T) {
+	ctx := context.Background()
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte(strings.Repeat("hello ", 100))) //nolint:errcheck
+	zw.Close()                                      //nolint:errcheck
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(compressed.Bytes()) //nolint:errcheck
+		case "/latin1":
+			w.Header().Set("Content-Type", "text/plain; charset=windows-1252")
+			io.WriteString(w, "caf\xe9") //nolint:errcheck
+		}
+	}))
+	defer server.Close()
+
+	metrics := NewMetrics()
+	crawler := New(ctx, Config{
+		UserAgent:     "test",
+		Metrics:       metrics,
+		DecodeCharset: true,
+		ResultHandler: func(res *Result) error {
+			_, err := io.Copy(io.Discard, res.Response.Body)
+			return err
+		},
+	})
+	urls := func(yield func(string) bool) {
+		_ = yield(server.URL+"/gzip") && yield(server.URL+"/latin1")
+	}
+	if err := crawler.Run(ctx, urls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Both bodies count as sent, not as decoded
+	var out bytes.Buffer
+	metrics.write(&out)
+	line := "crawl_received_bytes_total " + strconv.Itoa(compressed.Len()+4) + "\n"
+	if !strings.Contains(out.String(), line) {
+		t.Errorf("expected %q in\n%s", line, out.String())
+	}
+}
@@ -0,0 +1,117 @@
+package crawl
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Fetch is a single attempt at fetching a URL, as passed through the Middleware.
+type Fetch struct {
+	// URL is the URL as yielded by the generator or found as a link.
+	URL string
+
+	// Meta is the metadata of the Item.
+	Meta any
+
+	// Attempt is the attempt number, starting at 1.
+	Attempt int
+
+	// Depth is the number of links followed to reach the URL, 0 for generator URLs.
+	Depth int
+
+	// Request is the request to send, with the headers of the profile set. Middleware may
+	// modify it, or replace it with a clone that keeps its context. A response without a
+	// Request, made up by middleware, gets the Request as the chain left it, and an empty
+	// Body and Header if it has none.
+	Request *http.Request
+
+	// Result is the result of the attempt. Connection details and timings are filled in
+	// after the chain returns.
+	Result *Result
+}
+
+// FetchFunc sends the request of a Fetch and returns the response.
+type FetchFunc func(f *Fetch) (*http.Response, error)
+
+// Middleware wraps a FetchFunc, like http.RoundTripper chaining, to observe or change
+// requests and responses. It may also answer without calling next, e.g. from a cache.
+// Errors that are not an *Error are reported as KindMiddleware.
+type Middleware func(next FetchFunc) FetchFunc
+
+// chain composes middleware around send, the first being the outermost.
+func chain(middleware []Middleware, send FetchFunc) FetchFunc {
+	fetch := send
+	for _, m := range slices.Backward(middleware) {
+		fetch = m(fetch)
+	}
+	return fetch
+}
+
+// LoggingMiddleware logs every attempt with its status or error and duration to w.
+// If w is nil, logs to stderr.
+func LoggingMiddleware(w io.Writer) Middleware {
+	if w == nil {
+		w = os.Stderr
+	}
+	var mu sync.Mutex
+	return func(next FetchFunc) FetchFunc {
+		return func(f *Fetch) (*http.Response, error) {
+			start := time.Now()
+			resp, err := next(f)
+			elapsed := time.Since(start).Round(time.Millisecond)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				fmt.Fprintf(w, "%s %s attempt %d -> error %v (%v)\n", f.Request.Method, f.URL, f.Attempt, err, elapsed)
+			} else {
+				fmt.Fprintf(w, "%s %s attempt %d -> %d (%v)\n", f.Request.Method, f.URL, f.Attempt, resp.StatusCode, elapsed)
+			}
+			return resp, err
+		}
+	}
+}
+
+// HeaderMiddleware sets the given headers on every request, replacing those of the
+// profile and the RequestBuilder, e.g. for authentication.
+func HeaderMiddleware(header http.Header) Middleware {
+	return func(next FetchFunc) FetchFunc {
+		return func(f *Fetch) (*http.Response, error) {
+			for name, values := range header {
+				f.Request.Header[http.CanonicalHeaderKey(name)] = slices.Clone(values)
+			}
+			return next(f)
+		}
+	}
+}
+
+// StatusFilterMiddleware only passes responses with one of the given status codes to the
+// handlers. Other responses are closed and reported as a KindPolicy error wrapping a *StatusError.
+// Filtered responses are not retried, so with a RetryPolicy, include the codes it retries.
+func StatusFilterMiddleware(codes ...int) Middleware {
+	return func(next FetchFunc) FetchFunc {
+		return func(f *Fetch) (*http.Response, error) {
+			resp, err := next(f)
+			if err != nil || slices.Contains(codes, resp.StatusCode) {
+				return resp, err
+			}
+			resp.Body.Close() //nolint:errcheck
+			return nil, newError(KindPolicy, f.URL, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status})
+		}
+	}
+}
+
+// StatusError is a response that was filtered by its status code.
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("filtered response status %s", e.Status)
+}
@@ -0,0 +1,210 @@
+package crawl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		case "/flaky":
+			if r.Header.Get("X-Attempt") == "1" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+		io.WriteString(w, r.Header.Get("Authorization")) //nolint:errcheck
+	}))
+	defer server.Close()
+
+	var mu sync.Mutex
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next FetchFunc) FetchFunc {
+			return func(f *Fetch) (*http.Response, error) {
+				mu.Lock()
+				order = append(order, name)
+				mu.Unlock()
+				f.Request.Header.Set("X-Attempt", strings.Repeat("1", f.Attempt))
+				return next(f)
+			}
+		}
+	}
+	// A cache that answers without sending a request
+	cache := func(next FetchFunc) FetchFunc {
+		return func(f *Fetch) (*http.Response, error) {
+			switch {
+			case strings.HasSuffix(f.URL, "/cached"):
+				return &http.Response{StatusCode: http.StatusOK, Proto: "HTTP/1.1", Body: io.NopCloser(strings.NewReader("from cache"))}, nil
+			case strings.HasSuffix(f.URL, "/denied"):
+				return nil, errors.New("denied")
+			}
+			return next(f)
+		}
+	}
+
+	var logs bytes.Buffer
+	bodies := map[string]string{}
+	errs := map[string]error{}
+	crawler := New(ctx, Config{
+		WorkerCount: 1,
+		UserAgent:   "test",
+		RetryPolicy: BackoffRetryPolicy(2, time.Millisecond, time.Millisecond),
+		Middleware: []Middleware{
+			trace("outer"),
+			LoggingMiddleware(&logs),
+			HeaderMiddleware(http.Header{"authorization": {"Bearer secret"}}),
+			StatusFilterMiddleware(http.StatusOK, http.StatusServiceUnavailable),
+			cache,
+			trace("inner"),
+		},
+		ResultHandler: func(res *Result) error {
+			body, err := io.ReadAll(res.Response.Body)
+			bodies[res.URL] = string(body)
+			return err
+		},
+		ErrorHandler: func(url string, err error) { errs[url] = err },
+	})
+
+	urls := func(yield func(string) bool) {
+		for _, path := range []string{"/ok", "/missing", "/flaky", "/cached", "/denied"} {
+			if !yield(server.URL + path) {
+				return
+			}
+		}
+	}
+	if err := crawler.Run(ctx, urls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if bodies[server.URL+"/ok"] != "Bearer secret" {
+		t.Errorf("expected the injected header, got %q", bodies[server.URL+"/ok"])
+	}
+	if bodies[server.URL+"/flaky"] != "Bearer secret" {
+		t.Errorf("expected the second attempt to succeed, got %q", bodies[server.URL+"/flaky"])
+	}
+	if bodies[server.URL+"/cached"] != "from cache" {
+		t.Errorf("expected the cached response, got %q", bodies[server.URL+"/cached"])
+	}
+
+	var statusErr *StatusError
+	if err := errs[server.URL+"/missing"]; !IsPolicy(err) || !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected a filtered 404, got %v", err)
+	}
+	if err := errs[server.URL+"/denied"]; KindOf(err) != KindMiddleware {
+		t.Errorf("expected a middleware error, got %v", err)
+	}
+	if len(errs) != 2 {
+		t.Errorf("expected 2 errors, got %v", errs)
+	}
+
+	// 5 URLs plus two retries pass the outer middleware; the cache answers three attempts
+	var outer, inner int
+	for _, name := range order {
+		if name == "outer" {
+			outer++
+		} else {
+			inner++
+		}
+	}
+	if outer != 7 || inner != 4 || order[0] != "outer" || order[1] != "inner" {
+		t.Errorf("unexpected middleware calls: %v", order)
+	}
+
+	if lines := strings.Count(logs.String(), "\n"); lines != 7 {
+		t.Errorf("expected 7 log lines, got %d:\n%s", lines, logs.String())
+	}
+	if !strings.Contains(logs.String(), "GET "+server.URL+"/flaky attempt 2 -> 200") {
+		t.Errorf("expected the retry to be logged, got\n%s", logs.String())
+	}
+}
+
+func TestMiddlewareReplacesRequest(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// Replaces the request with a clone, then answers /cached itself
+	replace := func(next FetchFunc) FetchFunc {
+		return func(f *Fetch) (*http.Response, error) {
+			f.Request = f.Request.Clone(f.Request.Context())
+			f.Request.Header.Set("X-Replaced", "yes")
+			if strings.HasSuffix(f.URL, "/cached") {
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+			}
+			return next(f)
+		}
+	}
+
+	replaced := map[string]string{}
+	crawler := New(ctx, Config{
+		WorkerCount: 1,
+		UserAgent:   "test",
+		Middleware:  []Middleware{replace},
+		ResultHandler: func(res *Result) error {
+			replaced[res.URL] = res.Response.Request.Header.Get("X-Replaced")
+			return nil
+		},
+	})
+
+	urls := func(yield func(string) bool) {
+		_ = yield(server.URL+"/sent") && yield(server.URL+"/cached")
+	}
+	if err := crawler.Run(ctx, urls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, path := range []string{"/sent", "/cached"} {
+		if got := replaced[server.URL+path]; got != "yes" {
+			t.Errorf("%s: expected the replaced request on the response, got header %q", path, got)
+		}
+	}
+}
+
+func TestMiddlewareBareResponse(t *testing.T) {
+	ctx := context.Background()
+
+	// Answers without a Body, Header or Request
+	bare := func(next FetchFunc) FetchFunc {
+		return func(f *Fetch) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusNoContent}, nil
+		}
+	}
+
+	var status int
+	var body []byte
+	crawler := New(ctx, Config{
+		UserAgent:     "test",
+		DecodeCharset: true,
+		MaxBodyBytes:  10,
+		Middleware:    []Middleware{bare},
+		ResultHandler: func(res *Result) error {
+			var err error
+			status = res.Response.StatusCode
+			body, err = io.ReadAll(res.Response.Body)
+			return err
+		},
+		ErrorHandler: func(url string, err error) { t.Errorf("unexpected error for %s: %v", url, err) },
+	})
+	if err := crawler.Run(ctx, func(yield func(string) bool) { yield("http://bare.invalid/") }); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if status != http.StatusNoContent || len(body) != 0 {
+		t.Errorf("expected an empty 204, got %d with %q", status, body)
+	}
+}
@@ -0,0 +1,286 @@
+package crawl
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+)
+
+// Versions used by the built-in profiles of browsers whose version is not passed in.
+const (
+	firefoxVersion = "147.0"
+	safariVersion  = "26.2"
+)
+
+// HeaderField is a single request header.
+type HeaderField struct {
+	Name  string
+	Value string
+}
+
+// HeaderProfile describes the headers a browser sends for a top-level navigation.
+// Headers are listed in the order the browser sends them.
+type HeaderProfile struct {
+	// Name identifies the profile, e.g. "chrome-windows".
+	Name string
+
+	// Headers are the request headers in wire order, including User-Agent.
+	Headers []HeaderField
+
+	// HTTP2 describes how the browser opens HTTP/2 connections, for Config.OrderedHeaders.
+	// If nil, only HTTP/1.1 is negotiated.
+	HTTP2 *HTTP2Profile
+}
+
+// HTTP2Profile describes the HTTP/2 connection preface and request framing of a browser.
+type HTTP2Profile struct {
+	// Settings are the SETTINGS parameters, in the order the browser sends them.
+	Settings []HTTP2Setting
+
+	// WindowUpdate is the connection window increment sent after the SETTINGS, or 0 for none.
+	WindowUpdate uint32
+
+	// PseudoHeaderOrder is the order of the :method, :authority, :scheme and :path pseudo-headers.
+	PseudoHeaderOrder []string
+
+	// Weight is the stream priority weight (1-256) sent in the HEADERS frame, or 0 for none.
+	Weight int
+}
+
+// HTTP2Setting is a single HTTP/2 SETTINGS parameter.
+type HTTP2Setting struct {
+	ID  uint16
+	Val uint32
+}
+
+type profileKey struct{}
+
+// withProfile attaches a HeaderProfile to a request context, for the ordered transport.
+func withProfile(ctx context.Context, p *HeaderProfile) context.Context {
+	return context.WithValue(ctx, profileKey{}, p)
+}
+
+// profileFromContext returns the HeaderProfile attached to ctx, or nil.
+func profileFromContext(ctx context.Context) *HeaderProfile {
+	p, _ := ctx.Value(profileKey{}).(*HeaderProfile)
+	return p
+}
+
+// Get returns the value of the named header, or "" if the profile does not send it.
+func (p *HeaderProfile) Get(name string) string {
+	name = http.CanonicalHeaderKey(name)
+	for _, f := range p.Headers {
+		if http.CanonicalHeaderKey(f.Name) == name {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// UserAgent returns the User-Agent of the profile.
+func (p *HeaderProfile) UserAgent() string {
+	return p.Get("User-Agent")
+}
+
+// withUserAgent returns a copy of the profile with a different User-Agent.
+func (p *HeaderProfile) withUserAgent(userAgent string) *HeaderProfile {
+	cp := &HeaderProfile{Name: p.Name, Headers: make([]HeaderField, len(p.Headers)), HTTP2: p.HTTP2}
+	copy(cp.Headers, p.Headers)
+	for i, f := range cp.Headers {
+		if f.Name == "User-Agent" {
+			cp.Headers[i].Value = userAgent
+		}
+	}
+	return cp
+}
+
+const (
+	chromeAccept  = "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7"
+	firefoxAccept = "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+	safariAccept  = "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+	referer       = "https://www.google.com/"
+)
+
+var (
+	chromeHTTP2 = &HTTP2Profile{
+		Settings:          []HTTP2Setting{{1, 65536}, {2, 0}, {4, 6291456}, {6, 262144}},
+		WindowUpdate:      15663105,
+		PseudoHeaderOrder: []string{":method", ":authority", ":scheme", ":path"},
+		Weight:            256,
+	}
+	firefoxHTTP2 = &HTTP2Profile{
+		Settings:          []HTTP2Setting{{1, 65536}, {2, 0}, {4, 131072}, {5, 16384}},
+		WindowUpdate:      12517377,
+		PseudoHeaderOrder: []string{":method", ":path", ":authority", ":scheme"},
+		Weight:            42,
+	}
+	safariHTTP2 = &HTTP2Profile{
+		Settings:          []HTTP2Setting{{2, 0}, {3, 100}, {4, 2097152}, {9, 1}},
+		WindowUpdate:      10420225,
+		PseudoHeaderOrder: []string{":method", ":scheme", ":path", ":authority"},
+	}
+)
+
+// chromiumProfile builds the headers of a Chromium-based browser.
+// Navigations come from a Google search, hence the cross-site Referer.
+func chromiumProfile(name, userAgent, secChUa, platform string, mobile bool) *HeaderProfile {
+	mobileFlag := "?0"
+	if mobile {
+		mobileFlag = "?1"
+	}
+	return &HeaderProfile{
+		Name: name,
+		Headers: []HeaderField{
+			{"Connection", "keep-alive"},
+			{"Cache-Control", "no-cache"},
+			{"Pragma", "no-cache"},
+			{"Sec-Ch-Ua", secChUa},
+			{"Sec-Ch-Ua-Mobile", mobileFlag},
+			{"Sec-Ch-Ua-Platform", `"` + platform + `"`},
+			{"Upgrade-Insecure-Requests", "1"},
+			{"User-Agent", userAgent},
+			{"Accept", chromeAccept},
+			{"Sec-Fetch-Site", "cross-site"},
+			{"Sec-Fetch-Mode", "navigate"},
+			{"Sec-Fetch-User", "?1"},
+			{"Sec-Fetch-Dest", "document"},
+			{"Referer", referer},
+			{"Accept-Encoding", "gzip, deflate, br, zstd"},
+			{"Accept-Language", "en-US,en;q=0.9"},
+			{"Priority", "u=0, i"},
+		},
+		HTTP2: chromeHTTP2,
+	}
+}
+
+// defaultProfile is the profile used without Config.HeaderProfiles: Chrome on macOS with
+// userAgent, keeping the Accept-Language and Sec-Fetch-Site the crawler sent before profiles.
+func defaultProfile(userAgent string) *HeaderProfile {
+	p := ChromeMacOSProfile(extractChromeVersion(userAgent)).withUserAgent(userAgent)
+	for i, f := range p.Headers {
+		switch f.Name {
+		case "Accept-Language":
+			p.Headers[i].Value = "en-GB,en-US;q=0.9,en;q=0.8,nl;q=0.7,sv;q=0.6"
+		case "Sec-Fetch-Site":
+			p.Headers[i].Value = "same-origin"
+		}
+	}
+	return p
+}
+
+// chromeVersionOrDefault returns version, or the version of the default user agent if empty.
+func chromeVersionOrDefault(version string) string {
+	if version == "" {
+		return extractChromeVersion(defaultUserAgent)
+	}
+	return version
+}
+
+// ChromeWindowsProfile returns the profile of Chrome on Windows with the given major version.
+// If version is empty, the version of the built-in default user agent is used.
+func ChromeWindowsProfile(version string) *HeaderProfile {
+	version = chromeVersionOrDefault(version)
+	ua := fmt.Sprintf("Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/%s.0.0.0 Safari/537.36", version)
+	return chromiumProfile("chrome-windows", ua, generateSecChUa(ua), "Windows", false)
+}
+
+// ChromeMacOSProfile returns the profile of Chrome on macOS with the given major version.
+// If version is empty, the version of the built-in default user agent is used.
+func ChromeMacOSProfile(version string) *HeaderProfile {
+	version = chromeVersionOrDefault(version)
+	ua := fmt.Sprintf("Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/%s.0.0.0 Safari/537.36", version)
+	return chromiumProfile("chrome-macos", ua, generateSecChUa(ua), "macOS", false)
+}
+
+// ChromeLinuxProfile returns the profile of Chrome on Linux with the given major version.
+// If version is empty, the version of the built-in default user agent is used.
+func ChromeLinuxProfile(version string) *HeaderProfile {
+	version = chromeVersionOrDefault(version)
+	ua := fmt.Sprintf("Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/%s.0.0.0 Safari/537.36", version)
+	return chromiumProfile("chrome-linux", ua, generateSecChUa(ua), "Linux", false)
+}
+
+// ChromeAndroidProfile returns the profile of Chrome on Android with the given major version.
+// If version is empty, the version of the built-in default user agent is used.
+func ChromeAndroidProfile(version string) *HeaderProfile {
+	version = chromeVersionOrDefault(version)
+	ua := fmt.Sprintf("Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/%s.0.0.0 Mobile Safari/537.36", version)
+	return chromiumProfile("chrome-android", ua, generateSecChUa(ua), "Android", true)
+}
+
+// EdgeProfile returns the profile of Edge on Windows with the given major version.
+// If version is empty, the version of the built-in default user agent is used.
+func EdgeProfile(version string) *HeaderProfile {
+	version = chromeVersionOrDefault(version)
+	ua := fmt.Sprintf("Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/%s.0.0.0 Safari/537.36 Edg/%s.0.0.0", version, version)
+	secChUa := fmt.Sprintf(`"Chromium";v="%s", "Microsoft Edge";v="%s", "Not_A Brand";v="99"`, version, version)
+	return chromiumProfile("edge-windows", ua, secChUa, "Windows", false)
+}
+
+// FirefoxProfile returns the profile of Firefox on Windows.
+func FirefoxProfile() *HeaderProfile {
+	return &HeaderProfile{
+		Name: "firefox-windows",
+		Headers: []HeaderField{
+			{"User-Agent", fmt.Sprintf("Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:%s) Gecko/20100101 Firefox/%s", firefoxVersion, firefoxVersion)},
+			{"Accept", firefoxAccept},
+			{"Accept-Language", "en-US,en;q=0.5"},
+			{"Accept-Encoding", "gzip, deflate, br, zstd"},
+			{"Referer", referer},
+			{"Connection", "keep-alive"},
+			{"Upgrade-Insecure-Requests", "1"},
+			{"Sec-Fetch-Dest", "document"},
+			{"Sec-Fetch-Mode", "navigate"},
+			{"Sec-Fetch-Site", "cross-site"},
+			{"Sec-Fetch-User", "?1"},
+			{"Priority", "u=0, i"},
+		},
+		HTTP2: firefoxHTTP2,
+	}
+}
+
+// SafariProfile returns the profile of Safari on macOS.
+func SafariProfile() *HeaderProfile {
+	return &HeaderProfile{
+		Name: "safari-macos",
+		Headers: []HeaderField{
+			{"Accept", safariAccept},
+			{"Sec-Fetch-Site", "cross-site"},
+			{"Accept-Language", "en-US,en;q=0.9"},
+			{"Accept-Encoding", "gzip, deflate, br"},
+			{"Sec-Fetch-Mode", "navigate"},
+			{"User-Agent", fmt.Sprintf("Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/%s Safari/605.1.15", safariVersion)},
+			{"Referer", referer},
+			{"Sec-Fetch-Dest", "document"},
+			{"Priority", "u=0, i"},
+			{"Connection", "keep-alive"},
+		},
+		HTTP2: safariHTTP2,
+	}
+}
+
+// BrowserProfiles returns all built-in profiles, with Chromium-based browsers at the given
+// major version. If version is empty, the version of the built-in default user agent is used.
+func BrowserProfiles(chromeVersion string) []*HeaderProfile {
+	return []*HeaderProfile{
+		ChromeWindowsProfile(chromeVersion),
+		ChromeMacOSProfile(chromeVersion),
+		ChromeLinuxProfile(chromeVersion),
+		ChromeAndroidProfile(chromeVersion),
+		EdgeProfile(chromeVersion),
+		FirefoxProfile(),
+		SafariProfile(),
+	}
+}
+
+// profileFor returns the profile for host. With several profiles, the choice
+// depends only on the host, so all requests to a host look like the same browser.
+func (c *Crawler) profileFor(host string) *HeaderProfile {
+	if len(c.profiles) == 1 {
+		return c.profiles[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(host)) //nolint:errcheck
+	return c.profiles[h.Sum32()%uint32(len(c.profiles))]
+}
@@ -0,0 +1,102 @@
+package crawl
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBrowserProfiles(t *testing.T) {
+	platforms := map[string]string{
+		`"Windows"`: "Windows NT",
+		`"macOS"`:   "Macintosh",
+		`"Linux"`:   "X11; Linux",
+		`"Android"`: "Android",
+	}
+
+	for _, p := range BrowserProfiles("150") {
+		t.Run(p.Name, func(t *testing.T) {
+			ua := p.UserAgent()
+			if ua == "" || p.Get("Accept") == "" || p.Get("Accept-Encoding") == "" {
+				t.Fatalf("expected User-Agent, Accept and Accept-Encoding, got %+v", p.Headers)
+			}
+
+			secChUa := p.Get("sec-ch-ua")
+			if strings.Contains(ua, "Chrome/") {
+				if !strings.Contains(ua, "Chrome/150.") || !strings.Contains(secChUa, `v="150"`) {
+					t.Errorf("expected version 150 in UA and Sec-Ch-Ua, got %q and %q", ua, secChUa)
+				}
+				platform := p.Get("Sec-Ch-Ua-Platform")
+				if !strings.Contains(ua, platforms[platform]) {
+					t.Errorf("platform %s does not match UA %q", platform, ua)
+				}
+				mobile := strings.Contains(ua, "Mobile")
+				if (p.Get("Sec-Ch-Ua-Mobile") == "?1") != mobile {
+					t.Errorf("mobile flag %s does not match UA %q", p.Get("Sec-Ch-Ua-Mobile"), ua)
+				}
+			} else if secChUa != "" {
+				t.Errorf("expected no Sec-Ch-Ua for %q, got %q", ua, secChUa)
+			}
+		})
+	}
+
+	if ua := EdgeProfile("150").Get("Sec-Ch-Ua"); !strings.Contains(ua, "Microsoft Edge") {
+		t.Errorf("expected Edge brand, got %q", ua)
+	}
+	if ua := ChromeWindowsProfile("").UserAgent(); !strings.Contains(ua, "Chrome/"+extractChromeVersion(defaultUserAgent)+".") {
+		t.Errorf("expected default version, got %q", ua)
+	}
+
+	// The default profile keeps the headers sent before profiles existed
+	p := New(context.Background(), Config{UserAgent: "test"}).profiles[0]
+	if p.UserAgent() != "test" || p.Get("Accept-Language") != "en-GB,en-US;q=0.9,en;q=0.8,nl;q=0.7,sv;q=0.6" || p.Get("Sec-Fetch-Site") != "same-origin" {
+		t.Errorf("unexpected default profile: %v", p.Headers)
+	}
+}
+
+func TestHeaderProfileRotation(t *testing.T) {
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	agents := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		agents[r.UserAgent()] = true
+	}))
+	defer server.Close()
+
+	crawler := New(ctx, Config{
+		HeaderProfiles:  BrowserProfiles(""),
+		ResponseHandler: func(string, *http.Response) error { return nil },
+	})
+	urls := func(yield func(string) bool) {
+		for i := 0; i < 10; i++ {
+			if !yield(server.URL + "/page") {
+				return
+			}
+		}
+	}
+	if err := crawler.Run(ctx, urls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	expected := crawler.profileFor("127.0.0.1").UserAgent()
+	if len(agents) != 1 || !agents[expected] {
+		t.Errorf("expected only %q, got %v", expected, agents)
+	}
+
+	used := map[string]bool{}
+	for _, host := range []string{"a.example", "b.example", "c.example", "d.example", "e.example", "f.example"} {
+		if crawler.profileFor(host) != crawler.profileFor(host) {
+			t.Errorf("expected a stable profile for %s", host)
+		}
+		used[crawler.profileFor(host).Name] = true
+	}
+	if len(used) < 2 {
+		t.Errorf("expected hosts to be spread over profiles, got %v", used)
+	}
+}
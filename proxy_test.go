@@ -0,0 +1,271 @@
+package crawl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProxyPool(t *testing.T) {
+	proxies := []string{"http://a:8080", "socks5://b:1080", "https://user:secret@c:443"}
+
+	t.Run("round robin", func(t *testing.T) {
+		pool, err := NewProxyPool(proxies, ProxyOptions{})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		var got []string
+		for range 4 {
+			got = append(got, pool.pick("example.com").url.Host)
+		}
+		if expected := []string{"a:8080", "b:1080", "c:443", "a:8080"}; !slices.Equal(got, expected) {
+			t.Errorf("expected %v, got %v", expected, got)
+		}
+	})
+
+	t.Run("sticky per host", func(t *testing.T) {
+		pool, _ := NewProxyPool(proxies, ProxyOptions{Strategy: ProxyStickyPerHost})
+		first := pool.pick("example.com")
+		for range 5 {
+			if pool.pick("example.com") != first {
+				t.Fatal("expected the same proxy for a host")
+			}
+		}
+	})
+
+	t.Run("cool-down after failures", func(t *testing.T) {
+		now := time.Now()
+		pool, _ := NewProxyPool(proxies, ProxyOptions{Strategy: ProxyStickyPerHost, MaxFailures: 2, CoolDown: time.Minute})
+		pool.now = func() time.Time { return now }
+
+		sticky := pool.pick("example.com")
+		pool.report(sticky, errors.New("refused"))
+		if pool.pick("example.com") != sticky {
+			t.Fatal("expected proxy to stay in rotation after a single failure")
+		}
+		pool.report(sticky, errors.New("refused"))
+		if pool.pick("example.com") == sticky {
+			t.Fatal("expected proxy to be taken out of rotation")
+		}
+
+		now = now.Add(time.Minute)
+		if pool.pick("example.com") != sticky {
+			t.Error("expected proxy to be back after the cool-down")
+		}
+	})
+
+	t.Run("invalid proxies", func(t *testing.T) {
+		for _, proxies := range [][]string{nil, {"ftp://a"}, {"http://"}} {
+			if _, err := NewProxyPool(proxies, ProxyOptions{}); err == nil {
+				t.Errorf("expected an error for %v", proxies)
+			}
+		}
+	})
+
+	if got := proxyURL(t, proxies[2]); got != "https://user:xxxxx@c:443" {
+		t.Errorf("expected redacted proxy, got %q", got)
+	}
+}
+
+// proxyURL returns the proxy recorded in the Result of a request through a pool of one proxy.
+func proxyURL(t *testing.T, proxy string) string {
+	pool, _ := NewProxyPool([]string{proxy}, ProxyOptions{})
+	res := &Result{}
+	req, _ := http.NewRequestWithContext(withResult(context.Background(), res), "GET", "http://example.com/", nil)
+	if _, err := pool.proxyFor(req); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return res.Proxy
+}
+
+func TestCrawlThroughProxyPool(t *testing.T) {
+	ctx := context.Background()
+
+	// Forward proxies answer themselves instead of contacting the target
+	newProxy := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name+" "+r.URL.String()) //nolint:errcheck
+		}))
+	}
+	a, b := newProxy("a"), newProxy("b")
+	defer a.Close()
+	defer b.Close()
+
+	// A proxy that refuses connections
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	dead := "http://" + ln.Addr().String()
+	ln.Close()
+
+	pool, err := NewProxyPool([]string{a.URL, dead, b.URL}, ProxyOptions{MaxFailures: 1, CoolDown: time.Hour})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var mu sync.Mutex
+	bodies := map[string]string{} // by proxy
+	var failed int
+	crawler := New(ctx, Config{
+		WorkerCount: 1,
+		UserAgent:   "test",
+		ProxyPool:   pool,
+		ResultHandler: func(res *Result) error {
+			body, err := io.ReadAll(res.Response.Body)
+			mu.Lock()
+			defer mu.Unlock()
+			bodies[res.Proxy] = string(body)
+			return err
+		},
+		ErrorHandler: func(url string, err error) { failed++ },
+	})
+
+	urls := func(yield func(string) bool) {
+		for _, u := range []string{"http://one.example/", "http://two.example/", "http://three.example/", "http://four.example/"} {
+			if !yield(u) {
+				return
+			}
+		}
+	}
+	if err := crawler.Run(ctx, urls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// a, dead (fails and is taken out), b, a
+	if failed != 1 {
+		t.Errorf("expected 1 failed request through the dead proxy, got %d", failed)
+	}
+	if bodies[a.URL] != "a http://four.example/" || bodies[b.URL] != "b http://three.example/" {
+		t.Errorf("unexpected responses: %v", bodies)
+	}
+}
+
+func TestOrderedTransportProxy(t *testing.T) {
+	ctx := context.Background()
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello") //nolint:errcheck
+	}))
+	defer target.Close()
+
+	connected := make(chan string, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		connected <- r.Host
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		go io.Copy(upstream, conn) //nolint:errcheck
+		io.Copy(conn, upstream)    //nolint:errcheck
+	}))
+	defer proxy.Close()
+
+	pool, _ := NewProxyPool([]string{proxy.URL}, ProxyOptions{})
+	var body string
+	var via string
+	crawler := New(ctx, Config{
+		UserAgent:      "test",
+		OrderedHeaders: true,
+		ProxyPool:      pool,
+		ResultHandler: func(res *Result) error {
+			data, err := io.ReadAll(res.Response.Body)
+			body, via = string(data), res.Proxy
+			return err
+		},
+		ErrorHandler: func(url string, err error) { t.Errorf("unexpected error for %s: %v", url, err) },
+	})
+	if err := crawler.Run(ctx, func(yield func(string) bool) { yield(target.URL) }); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if body != "hello" || via != proxy.URL {
+		t.Errorf("expected hello via %s, got %q via %q", proxy.URL, body, via)
+	}
+	if host := <-connected; host != target.Listener.Addr().String() {
+		t.Errorf("expected CONNECT to %s, got %q", target.Listener.Addr(), host)
+	}
+}
+
+func TestProxyPoolTargetFailures(t *testing.T) {
+	ctx := context.Background()
+
+	// A target with a certificate that does not verify
+	target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	// A CONNECT proxy that refuses tunnels to refused
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	refused := ln.Addr().String()
+	ln.Close()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect || r.Host == refused {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		go io.Copy(upstream, conn) //nolint:errcheck
+		io.Copy(conn, upstream)    //nolint:errcheck
+	}))
+	defer proxy.Close()
+
+	for _, ordered := range []bool{false, true} {
+		pool, _ := NewProxyPool([]string{proxy.URL}, ProxyOptions{MaxFailures: 1, CoolDown: time.Hour})
+		var kinds []ErrorKind
+		crawler := New(ctx, Config{
+			WorkerCount:    1,
+			UserAgent:      "test",
+			VerifyTLS:      true,
+			OrderedHeaders: ordered,
+			ProxyPool:      pool,
+			ErrorHandler:   func(url string, err error) { kinds = append(kinds, KindOf(err)) },
+		})
+		run := func(u string) {
+			if err := crawler.Run(ctx, func(yield func(string) bool) { yield(u) }); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+
+		run(target.URL)
+		if !slices.Equal(kinds, []ErrorKind{KindTLS}) {
+			t.Errorf("ordered=%v: expected a TLS error, got %v", ordered, kinds)
+		}
+		if !pool.proxies[0].disabledUntil.IsZero() {
+			t.Errorf("ordered=%v: expected a target failure not to count against the proxy", ordered)
+		}
+
+		run("https://" + refused + "/")
+		if pool.proxies[0].disabledUntil.IsZero() {
+			t.Errorf("ordered=%v: expected a refused CONNECT to count against the proxy", ordered)
+		}
+	}
+}
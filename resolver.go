@@ -0,0 +1,298 @@
+package crawl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptrace"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// IPPreference determines which address family a Resolver connects to first.
+type IPPreference int
+
+const (
+	// IPAny uses addresses in the order they were resolved.
+	IPAny IPPreference = iota
+	// PreferIPv4 tries IPv4 addresses before IPv6 addresses.
+	PreferIPv4
+	// PreferIPv6 tries IPv6 addresses before IPv4 addresses.
+	PreferIPv6
+	// OnlyIPv4 only resolves and connects to IPv4 addresses.
+	OnlyIPv4
+	// OnlyIPv6 only resolves and connects to IPv6 addresses.
+	OnlyIPv6
+)
+
+// ResolverOptions configures a Resolver.
+type ResolverOptions struct {
+	// Servers are the upstream DNS servers, as "ip" or "ip:port", used in turn.
+	// If empty, the system resolver configuration is used.
+	Servers []string
+
+	// CacheTTL is how long successful lookups and NXDOMAIN answers are cached.
+	// Other failures are not cached. Default: 1 minute.
+	CacheTTL time.Duration
+
+	// Overrides pins hosts to IP addresses, like curl --resolve. Keys are "host:port"
+	// or "host" for any port, values are IP addresses.
+	Overrides map[string]string
+
+	// Prefer determines the address family that is tried first. Default: IPAny.
+	Prefer IPPreference
+}
+
+// Resolver resolves host names for the crawler's connections, with an in-process cache
+// that merges concurrent lookups of a host, custom upstream servers and per-host overrides.
+// Its DialContext can be used as http.Transport.DialContext. It is safe for concurrent use.
+type Resolver struct {
+	opts      ResolverOptions
+	overrides map[string]netip.Addr
+	resolver  *net.Resolver
+	dialer    *net.Dialer
+	now       func() time.Time
+
+	mu      sync.Mutex
+	cache   map[string]*dnsEntry
+	pruneAt int // cache size at which expired entries are dropped
+}
+
+// minDNSPrune is the cache size below which expired entries are kept.
+const minDNSPrune = 1000
+
+// dnsEntry is a cached or ongoing lookup. Concurrent lookups of a host share one entry.
+type dnsEntry struct {
+	ready   chan struct{} // closed once the lookup is done
+	addrs   []netip.Addr
+	err     error
+	expires time.Time
+}
+
+// NewResolver creates a Resolver with the given options.
+func NewResolver(opts ResolverOptions) (*Resolver, error) {
+	if opts.CacheTTL <= 0 {
+		opts.CacheTTL = time.Minute
+	}
+
+	r := &Resolver{
+		opts:      opts,
+		overrides: make(map[string]netip.Addr),
+		resolver:  net.DefaultResolver,
+		dialer:    &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		now:       time.Now,
+		cache:     make(map[string]*dnsEntry),
+		pruneAt:   minDNSPrune,
+	}
+	for key, value := range opts.Overrides {
+		ip, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse override %s for %s: %w", value, key, err)
+		}
+		r.overrides[key] = ip
+	}
+
+	if len(opts.Servers) > 0 {
+		servers := make([]string, len(opts.Servers))
+		for i, s := range opts.Servers {
+			if _, err := netip.ParseAddr(s); err == nil {
+				s = net.JoinHostPort(s, "53")
+			}
+			if _, err := netip.ParseAddrPort(s); err != nil {
+				return nil, fmt.Errorf("failed to parse DNS server %s: %w", opts.Servers[i], err)
+			}
+			servers[i] = s
+		}
+
+		// The Go resolver dials again for every attempt, so rotating fails over to the next server
+		var next atomic.Uint32
+		r.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				server := servers[int(next.Add(1)-1)%len(servers)]
+				return r.dialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+	return r, nil
+}
+
+// Dial connects to addr, resolving its host with the Resolver.
+func (r *Resolver) Dial(network, addr string) (net.Conn, error) {
+	return r.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to addr, resolving its host with the Resolver. The addresses
+// are tried in order of preference until a connection succeeds. DNS phases are
+// reported to the httptrace.ClientTrace in ctx.
+func (r *Resolver) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return r.dialer.DialContext(ctx, network, addr)
+	}
+
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.DNSStart != nil {
+		trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+	}
+	addrs, err := r.resolve(ctx, host, port)
+	if trace != nil && trace.DNSDone != nil {
+		info := httptrace.DNSDoneInfo{Err: err}
+		for _, a := range addrs {
+			info.Addrs = append(info.Addrs, net.IPAddr{IP: a.AsSlice()})
+		}
+		trace.DNSDone(info)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range addrs {
+		var conn net.Conn
+		conn, err = r.dialer.DialContext(ctx, network, net.JoinHostPort(a.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
+
+// resolve returns the addresses of host in order of preference, from an override,
+// the cache or a lookup.
+func (r *Resolver) resolve(ctx context.Context, host, port string) ([]netip.Addr, error) {
+	if ip, ok := r.overrides[net.JoinHostPort(host, port)]; ok {
+		return []netip.Addr{ip}, nil
+	}
+	if ip, ok := r.overrides[host]; ok {
+		return []netip.Addr{ip}, nil
+	}
+
+	r.mu.Lock()
+	e, ok := r.cache[host]
+	if ok {
+		select {
+		case <-e.ready:
+			ok = r.now().Before(e.expires)
+		default:
+		}
+	}
+	if !ok {
+		if len(r.cache) >= r.pruneAt {
+			r.prune()
+		}
+		e = &dnsEntry{ready: make(chan struct{})}
+		r.cache[host] = e
+		go r.lookup(host, e)
+	}
+	r.mu.Unlock()
+
+	select {
+	case <-e.ready:
+		return e.addrs, e.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// lookup resolves host into e. It does not use the context of the dial that started it,
+// as other dials may wait for it, and so the DNS phases are only traced by DialContext.
+func (r *Resolver) lookup(host string, e *dnsEntry) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.dialer.Timeout)
+	defer cancel()
+
+	network := "ip"
+	switch r.opts.Prefer {
+	case OnlyIPv4:
+		network = "ip4"
+	case OnlyIPv6:
+		network = "ip6"
+	}
+	addrs, err := r.resolver.LookupNetIP(ctx, network, host)
+	for i, a := range addrs {
+		addrs[i] = a.Unmap()
+	}
+	if err == nil && len(addrs) == 0 {
+		err = &net.DNSError{Err: "no suitable address found", Name: host, IsNotFound: true}
+	}
+	r.sortAddrs(addrs)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.addrs, e.err = addrs, err
+	var dnsErr *net.DNSError
+	if err == nil || (errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		e.expires = r.now().Add(r.opts.CacheTTL)
+	} else if r.cache[host] == e {
+		delete(r.cache, host)
+	}
+	close(e.ready)
+}
+
+// prune drops expired entries, and sets the size for the next prune. Must be called with mu held.
+func (r *Resolver) prune() {
+	now := r.now()
+	for host, e := range r.cache {
+		select {
+		case <-e.ready:
+			if !now.Before(e.expires) {
+				delete(r.cache, host)
+			}
+		default:
+		}
+	}
+	r.pruneAt = max(minDNSPrune, 2*len(r.cache))
+}
+
+// sortAddrs orders addresses by the preferred family, keeping the resolved order otherwise.
+func (r *Resolver) sortAddrs(addrs []netip.Addr) {
+	if r.opts.Prefer != PreferIPv4 && r.opts.Prefer != PreferIPv6 {
+		return
+	}
+	preferred := func(a netip.Addr) bool { return a.Is4() == (r.opts.Prefer == PreferIPv4) }
+	slices.SortStableFunc(addrs, func(a, b netip.Addr) int {
+		switch {
+		case preferred(a) && !preferred(b):
+			return -1
+		case !preferred(a) && preferred(b):
+			return 1
+		}
+		return 0
+	})
+}
+
+// DNSResult is the outcome of resolving a host name.
+type DNSResult struct {
+	// Host is the name that was resolved.
+	Host string
+
+	// Status is "NOERROR", "NXDOMAIN", "SERVFAIL" or "TIMEOUT".
+	Status string
+
+	// Addrs are the resolved IP addresses.
+	Addrs []string
+}
+
+// dnsStatus maps a lookup error to a DNS status.
+func dnsStatus(err error) string {
+	var dnsErr *net.DNSError
+	switch {
+	case err == nil:
+		return "NOERROR"
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		return "NXDOMAIN"
+	case errors.As(err, &dnsErr) && dnsErr.IsTimeout, errors.Is(err, context.DeadlineExceeded):
+		return "TIMEOUT"
+	default:
+		return "SERVFAIL"
+	}
+}
@@ -0,0 +1,257 @@
+package crawl
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// serveDNS runs a DNS server on UDP that answers ok.example with 127.0.0.1,
+// broken.example with SERVFAIL and everything else with NXDOMAIN.
+// It returns the server address and a function that counts the queries per name.
+func serveDNS(t *testing.T) (string, func(name string) int) {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	var mu sync.Mutex
+	queries := map[string]int{}
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var msg dnsmessage.Message
+			if err := msg.Unpack(buf[:n]); err != nil || len(msg.Questions) != 1 {
+				continue
+			}
+			q := msg.Questions[0]
+			mu.Lock()
+			queries[q.Name.String()]++
+			mu.Unlock()
+
+			resp := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: msg.ID, Response: true, Authoritative: true},
+				Questions: msg.Questions,
+			}
+			switch q.Name.String() {
+			case "ok.example.":
+				if q.Type == dnsmessage.TypeA {
+					resp.Answers = []dnsmessage.Resource{{
+						Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+						Body:   &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+					}}
+				}
+			case "broken.example.":
+				resp.RCode = dnsmessage.RCodeServerFailure
+			default:
+				resp.RCode = dnsmessage.RCodeNameError
+			}
+			packed, err := resp.Pack()
+			if err != nil {
+				continue
+			}
+			pc.WriteTo(packed, addr) //nolint:errcheck
+		}
+	}()
+
+	return pc.LocalAddr().String(), func(name string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return queries[name]
+	}
+}
+
+func TestResolver(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host)) //nolint:errcheck
+	}))
+	defer server.Close()
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+
+	dnsAddr, queries := serveDNS(t)
+	resolver, err := NewResolver(ResolverOptions{
+		Servers:   []string{dnsAddr},
+		Overrides: map[string]string{"pinned.example:" + strconv.Itoa(port): "127.0.0.1"},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	crawler := New(ctx, Config{
+		WorkerCount: 1,
+		UserAgent:   "test",
+		Resolver:    resolver,
+		// A new connection for every request, so every request resolves
+		Client: &http.Client{Transport: &http.Transport{DisableKeepAlives: true}},
+	})
+
+	urlFor := func(host string) string {
+		return (&url.URL{Scheme: "http", Host: net.JoinHostPort(host, strconv.Itoa(port)), Path: "/"}).String()
+	}
+	urls := func(yield func(string) bool) {
+		for _, host := range []string{"pinned.example", "ok.example", "ok.example", "missing.example", "broken.example"} {
+			if !yield(urlFor(host)) {
+				return
+			}
+		}
+	}
+
+	results := map[string]*DNSResult{}
+	for res, err := range crawler.Results(ctx, urls) {
+		if res == nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if res.Response != nil {
+			res.Response.Body.Close()
+		}
+		results[res.URL] = res.DNS
+	}
+
+	tests := []struct {
+		host   string
+		status string
+		addrs  []string
+	}{
+		{"pinned.example", "NOERROR", []string{"127.0.0.1"}},
+		{"ok.example", "NOERROR", []string{"127.0.0.1"}},
+		{"missing.example", "NXDOMAIN", nil},
+		{"broken.example", "SERVFAIL", nil},
+	}
+	for _, tt := range tests {
+		dns := results[urlFor(tt.host)]
+		if dns == nil {
+			t.Errorf("%s: expected a DNS result", tt.host)
+			continue
+		}
+		if dns.Host != tt.host || dns.Status != tt.status || !slices.Equal(dns.Addrs, tt.addrs) {
+			t.Errorf("%s: expected %s %v, got %+v", tt.host, tt.status, tt.addrs, dns)
+		}
+	}
+
+	if n := queries("pinned.example."); n != 0 {
+		t.Errorf("expected no queries for an overridden host, got %d", n)
+	}
+	if n := queries("ok.example."); n > 2 {
+		t.Errorf("expected the second lookup to be cached, got %d queries", n)
+	}
+}
+
+func TestResolverPreference(t *testing.T) {
+	addrs := func() []netip.Addr {
+		return []netip.Addr{
+			netip.MustParseAddr("2001:db8::1"),
+			netip.MustParseAddr("192.0.2.1"),
+			netip.MustParseAddr("2001:db8::2"),
+			netip.MustParseAddr("192.0.2.2"),
+		}
+	}
+
+	tests := map[IPPreference][]string{
+		IPAny:      {"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2"},
+		PreferIPv4: {"192.0.2.1", "192.0.2.2", "2001:db8::1", "2001:db8::2"},
+		PreferIPv6: {"2001:db8::1", "2001:db8::2", "192.0.2.1", "192.0.2.2"},
+	}
+	for prefer, expected := range tests {
+		r := &Resolver{opts: ResolverOptions{Prefer: prefer}}
+		sorted := addrs()
+		r.sortAddrs(sorted)
+
+		var got []string
+		for _, a := range sorted {
+			got = append(got, a.String())
+		}
+		if !slices.Equal(got, expected) {
+			t.Errorf("preference %d: expected %v, got %v", prefer, expected, got)
+		}
+	}
+}
+
+func TestResolverTracesOnce(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+
+	resolver, err := NewResolver(ResolverOptions{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	crawler := New(ctx, Config{
+		UserAgent: "test",
+		Resolver:  resolver,
+		Client:    &http.Client{Transport: &http.Transport{DisableKeepAlives: true}},
+	})
+
+	u := "http://" + net.JoinHostPort("localhost", strconv.Itoa(port)) + "/"
+	for res, err := range crawler.Results(ctx, slices.Values([]string{u})) {
+		if res == nil || res.Response == nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		res.Response.Body.Close()
+		addrs := slices.Clone(res.DNS.Addrs)
+		slices.Sort(addrs)
+		if len(addrs) == 0 || len(slices.Compact(addrs)) != len(res.DNS.Addrs) {
+			t.Errorf("expected each address once, got %v", res.DNS.Addrs)
+		}
+	}
+}
+
+func TestResolverCache(t *testing.T) {
+	ctx := context.Background()
+	dnsAddr, queries := serveDNS(t)
+	resolver, err := NewResolver(ResolverOptions{Servers: []string{dnsAddr}})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	now := time.Now()
+	resolver.now = func() time.Time { return now }
+
+	// Concurrent misses share a single lookup, of A and AAAA
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := resolver.resolve(ctx, "ok.example", "80"); err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := queries("ok.example."); n > 2 {
+		t.Errorf("expected a single lookup, got %d queries", n)
+	}
+
+	// Expired entries are dropped once the cache grows
+	for i := range minDNSPrune - 1 {
+		resolver.resolve(ctx, "missing"+strconv.Itoa(i)+".example", "80") //nolint:errcheck
+	}
+	now = now.Add(2 * time.Minute)
+	resolver.resolve(ctx, "new.example", "80") //nolint:errcheck
+	resolver.mu.Lock()
+	size := len(resolver.cache)
+	resolver.mu.Unlock()
+	if size != 1 {
+		t.Errorf("expected expired entries to be dropped, got %d entries", size)
+	}
+}
@@ -0,0 +1,303 @@
+package crawl
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Result describes the outcome of crawling a single URL.
+type Result struct {
+	// URL is the URL as yielded by the generator.
+	URL string
+
+	// Meta is the metadata of the Item, nil for URLs without metadata.
+	Meta any
+
+	// FinalURL is the URL of the final response, after redirects.
+	FinalURL string
+
+	// Redirects lists every redirect hop that was followed, in order.
+	Redirects []Redirect
+
+	// RemoteAddr is the IP address and port of the server that sent the final response.
+	RemoteAddr string
+
+	// DNS is the outcome of the last host name lookup, also if it failed.
+	// Nil if no lookup was needed, e.g. because a connection was reused.
+	DNS *DNSResult
+
+	// TLSVersion and TLSCipher describe the TLS connection, e.g. "TLS 1.3" and
+	// "TLS_AES_128_GCM_SHA256". Empty for plain HTTP.
+	TLSVersion string
+	TLSCipher  string
+
+	// Certificate describes the server certificate and its verification. Nil for plain HTTP.
+	Certificate *Certificate
+
+	// Proxy is the proxy that served the final response, without password. Empty without a ProxyPool.
+	Proxy string
+	proxy *proxyState
+
+	// Protocol is the protocol of the final response, e.g. "HTTP/1.1" or "HTTP/2.0".
+	Protocol string
+
+	// Started is the time the request was sent. After retries, it is that of the last attempt.
+	Started time.Time
+
+	// Timings are the phase timings of the request.
+	Timings Timings
+
+	// BytesRead is the number of body bytes read from the final response.
+	BytesRead int64
+
+	// ContentEncoding is the Content-Encoding of the final response, e.g. "br", if the
+	// crawler decoded the body. CompressedBytes is the number of encoded body bytes read.
+	ContentEncoding string
+	CompressedBytes int64
+
+	// Charset is the character set the body was converted from, e.g. "windows-1252".
+	// Only set for text bodies with Config.DecodeCharset.
+	Charset string
+
+	// Truncated is set when the body was cut off at Config.MaxBodyBytes.
+	// It is known once the body has been read up to the limit.
+	Truncated bool
+
+	// Attempts is the number of attempts made, including retries.
+	Attempts int
+
+	// Response is the final response. Its body is closed after the handler returns.
+	Response *http.Response
+
+	failed   bool  // an error was reported for the URL, for Stats.Failed
+	received int64 // body bytes read from the transport, before decoding, for Metrics
+}
+
+// Redirect is a single redirect hop.
+type Redirect struct {
+	// URL is the URL that responded with a redirect.
+	URL string
+
+	// Request is the request that was sent to URL.
+	Request *http.Request
+
+	// StatusCode and Header are those of the redirect response.
+	StatusCode int
+	Header     http.Header
+}
+
+// Timings are the phase timings of a request. DNS, Connect and TLS are zero
+// when a connection was reused.
+type Timings struct {
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+
+	// TTFB is the time from sending the first request until the first response byte
+	// of the final response, including redirects.
+	TTFB time.Duration
+
+	// Total is the time from sending the first request until the body was fully
+	// read or closed. It is zero while the handler has not finished reading the body.
+	Total time.Duration
+}
+
+type resultKey struct{}
+
+// withResult attaches a Result to a request context, so redirect hops can be recorded.
+func withResult(ctx context.Context, res *Result) context.Context {
+	return context.WithValue(ctx, resultKey{}, res)
+}
+
+// resultFromContext returns the Result attached to ctx, or nil.
+func resultFromContext(ctx context.Context) *Result {
+	res, _ := ctx.Value(resultKey{}).(*Result)
+	return res
+}
+
+// recordRedirects wraps a redirect policy to record followed hops in the request's Result.
+func recordRedirects(policy func(req *http.Request, via []*http.Request) error) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if err := policy(req, via); err != nil {
+			if err == http.ErrUseLastResponse {
+				return err
+			}
+			return &redirectError{err}
+		}
+		if res := resultFromContext(req.Context()); res != nil && req.Response != nil {
+			res.Redirects = append(res.Redirects, Redirect{
+				URL:        via[len(via)-1].URL.String(),
+				Request:    via[len(via)-1],
+				StatusCode: req.Response.StatusCode,
+				Header:     req.Response.Header,
+			})
+		}
+		return nil
+	}
+}
+
+// tracer collects phase timings via httptrace. Hooks may be called from
+// transport goroutines, so all fields are guarded by mu.
+type tracer struct {
+	mu         sync.Mutex
+	start      time.Time
+	dnsStart   time.Time
+	connStart  time.Time
+	tlsStart   time.Time
+	timings    Timings
+	remoteAddr string
+	dns        *DNSResult
+}
+
+func newTracer() *tracer {
+	return &tracer{start: time.Now()}
+}
+
+// trace returns the httptrace hooks that feed the tracer.
+func (tr *tracer) trace() *httptrace.ClientTrace {
+	lock := func(f func()) {
+		tr.mu.Lock()
+		defer tr.mu.Unlock()
+		f()
+	}
+
+	return &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			lock(func() {
+				tr.dnsStart = time.Now()
+				tr.dns = &DNSResult{Host: info.Host}
+			})
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			lock(func() {
+				tr.timings.DNS = time.Since(tr.dnsStart)
+				tr.dns.Status = dnsStatus(info.Err)
+				for _, a := range info.Addrs {
+					tr.dns.Addrs = append(tr.dns.Addrs, a.String())
+				}
+			})
+		},
+		ConnectStart: func(_, _ string) {
+			lock(func() { tr.connStart = time.Now() })
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				lock(func() { tr.timings.Connect = time.Since(tr.connStart) })
+			}
+		},
+		TLSHandshakeStart: func() {
+			lock(func() { tr.tlsStart = time.Now() })
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			lock(func() { tr.timings.TLS = time.Since(tr.tlsStart) })
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			lock(func() {
+				tr.remoteAddr = info.Conn.RemoteAddr().String()
+				if info.Reused {
+					tr.timings.DNS, tr.timings.Connect, tr.timings.TLS = 0, 0, 0
+				}
+			})
+		},
+		GotFirstResponseByte: func() {
+			lock(func() { tr.timings.TTFB = time.Since(tr.start) })
+		},
+	}
+}
+
+// fill copies the collected trace data and response details into res.
+func (tr *tracer) fill(res *Result, resp *http.Response) {
+	tr.mu.Lock()
+	res.Started = tr.start
+	res.Timings = tr.timings
+	res.RemoteAddr = tr.remoteAddr
+	res.DNS = tr.dns
+	tr.mu.Unlock()
+
+	if resp == nil {
+		return
+	}
+	res.Response = resp
+	res.FinalURL = resp.Request.URL.String()
+	res.Protocol = resp.Proto
+	if resp.TLS != nil {
+		res.TLSVersion = tls.VersionName(resp.TLS.Version)
+		res.TLSCipher = tls.CipherSuiteName(resp.TLS.CipherSuite)
+	}
+}
+
+// countingBody counts the bytes read from a response body and
+// records the total request time once the body is fully read or closed.
+type countingBody struct {
+	io.ReadCloser
+	res   *Result
+	start time.Time
+	total *atomic.Int64 // bytes read in the run
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.res.BytesRead += int64(n)
+	b.total.Add(int64(n))
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *countingBody) finish() {
+	if b.res.Timings.Total == 0 {
+		b.res.Timings.Total = time.Since(b.start)
+	}
+}
+
+// receivedBody counts the body bytes read from the transport, before the crawler decodes
+// the content encoding or charset.
+type receivedBody struct {
+	io.ReadCloser
+	res *Result
+}
+
+func (b *receivedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.res.received += int64(n)
+	return n, err
+}
+
+// limitedBody ends a response body at a maximum size, and marks the result as
+// truncated if the body continues past it.
+type limitedBody struct {
+	io.ReadCloser
+	res       *Result
+	remaining int64
+	probed    bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		if !b.probed {
+			b.probed = true
+			var probe [1]byte
+			n, _ := io.ReadFull(b.ReadCloser, probe[:])
+			b.res.Truncated = n > 0
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
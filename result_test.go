@@ -0,0 +1,132 @@
+package crawl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResultHandler(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/start" {
+			http.Redirect(w, r, "/final", http.StatusMovedPermanently)
+			return
+		}
+		w.Write([]byte("hello world")) //nolint:errcheck
+	}))
+	defer server.Close()
+
+	var res *Result
+	crawler := New(ctx, Config{
+		UserAgent: "test",
+		ResultHandler: func(r *Result) error {
+			if _, err := io.ReadAll(r.Response.Body); err != nil {
+				return err
+			}
+			res = r
+			return nil
+		},
+	})
+
+	urls := func(yield func(string) bool) { yield(server.URL + "/start") }
+	if err := crawler.Run(ctx, urls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if res == nil {
+		t.Fatal("expected result handler to be called")
+	}
+	if res.URL != server.URL+"/start" || res.FinalURL != server.URL+"/final" {
+		t.Errorf("unexpected URLs: %s -> %s", res.URL, res.FinalURL)
+	}
+	if len(res.Redirects) != 1 || res.Redirects[0].StatusCode != http.StatusMovedPermanently || res.Redirects[0].URL != res.URL {
+		t.Errorf("unexpected redirects: %+v", res.Redirects)
+	}
+	if !strings.HasPrefix(res.RemoteAddr, "127.0.0.1:") {
+		t.Errorf("expected remote address on 127.0.0.1, got %q", res.RemoteAddr)
+	}
+	if res.TLSVersion == "" || res.TLSCipher == "" {
+		t.Errorf("expected TLS details, got %q %q", res.TLSVersion, res.TLSCipher)
+	}
+	if res.Protocol != "HTTP/1.1" {
+		t.Errorf("expected HTTP/1.1, got %q", res.Protocol)
+	}
+	if res.BytesRead != int64(len("hello world")) {
+		t.Errorf("expected %d bytes read, got %d", len("hello world"), res.BytesRead)
+	}
+	if res.Attempts != 1 {
+		t.Errorf("expected 1 attempt, got %d", res.Attempts)
+	}
+	if res.Timings.TTFB <= 0 || res.Timings.Total < res.Timings.TTFB {
+		t.Errorf("unexpected timings: %+v", res.Timings)
+	}
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	ctx := context.Background()
+
+	// 10 MB of zeros compresses to about 10 KB
+	var bomb bytes.Buffer
+	zw := gzip.NewWriter(&bomb)
+	zw.Write(make([]byte, 10<<20)) //nolint:errcheck
+	zw.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/exact":
+			io.WriteString(w, strings.Repeat("x", 1024)) //nolint:errcheck
+		case "/bomb":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(bomb.Bytes()) //nolint:errcheck
+		case "/endless":
+			for r.Context().Err() == nil {
+				if _, err := w.Write(make([]byte, 4096)); err != nil {
+					return
+				}
+			}
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path      string
+		truncated bool
+	}{
+		{"/exact", false},
+		{"/bomb", true},
+		{"/endless", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			var res *Result
+			var n int
+			crawler := New(ctx, Config{
+				UserAgent:    "test",
+				MaxBodyBytes: 1024,
+				ResultHandler: func(r *Result) error {
+					body, err := io.ReadAll(r.Response.Body)
+					res, n = r, len(body)
+					return err
+				},
+				ErrorHandler: func(url string, err error) { t.Errorf("unexpected error for %s: %v", url, err) },
+			})
+			if err := crawler.Run(ctx, func(yield func(string) bool) { yield(server.URL + tt.path) }); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if n != 1024 || res.BytesRead != 1024 {
+				t.Errorf("expected 1024 bytes, got %d (BytesRead %d)", n, res.BytesRead)
+			}
+			if res.Truncated != tt.truncated {
+				t.Errorf("expected truncated %v, got %v", tt.truncated, res.Truncated)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package crawl
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultRetryPolicy retries up to maxAttempts in total, with a jittered
+// exponential backoff starting at 1 second and capped at 1 minute.
+func DefaultRetryPolicy(maxAttempts int) RetryPolicy {
+	return BackoffRetryPolicy(maxAttempts, time.Second, time.Minute)
+}
+
+// BackoffRetryPolicy retries transport errors, except redirects refused by the
+// RedirectionPolicy and policy errors such as filtered responses, and 429, 500, 502,
+// 503 and 504 responses up to maxAttempts in total. The delay doubles with every attempt,
+// starting at base and capped at maxDelay, with random jitter of up to half the delay.
+// A Retry-After header on 429 and 503 responses takes precedence over the backoff;
+// if it asks to wait longer than maxDelay, the request is not retried.
+func BackoffRetryPolicy(maxAttempts int, base, maxDelay time.Duration) RetryPolicy {
+	return func(_ string, attempt int, resp *http.Response, err error) (bool, time.Duration) {
+		if attempt >= maxAttempts {
+			return false, 0
+		}
+
+		if err != nil {
+			if errors.Is(err, context.Canceled) || IsRedirect(err) || IsPolicy(err) {
+				return false, 0
+			}
+			return true, backoff(attempt, base, maxDelay)
+		}
+
+		switch resp.StatusCode {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				return delay <= maxDelay, delay
+			}
+		case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		default:
+			return false, 0
+		}
+		return true, backoff(attempt, base, maxDelay)
+	}
+}
+
+// backoff returns the jittered delay before the attempt following the given one.
+func backoff(attempt int, base, maxDelay time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+	if half := delay / 2; half > 0 {
+		delay = half + rand.N(half)
+	}
+	return delay
+}
+
+// parseRetryAfter parses a Retry-After header value, which is either
+// a number of seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,138 @@
+package crawl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("retries 503 with Retry-After", func(t *testing.T) {
+		var requests atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requests.Add(1) == 1 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		var status atomic.Int32
+		var errorCount atomic.Int32
+		crawler := New(ctx, Config{
+			WorkerCount: 2,
+			UserAgent:   "test",
+			RetryPolicy: DefaultRetryPolicy(3),
+			ResponseHandler: func(_ string, resp *http.Response) error {
+				status.Store(int32(resp.StatusCode))
+				return nil
+			},
+			ErrorHandler: func(string, error) { errorCount.Add(1) },
+		})
+
+		urls := func(yield func(string) bool) { yield(server.URL) }
+		if err := crawler.Run(ctx, urls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if requests.Load() != 2 {
+			t.Errorf("expected 2 requests, got %d", requests.Load())
+		}
+		if status.Load() != http.StatusOK {
+			t.Errorf("expected handler to see 200, got %d", status.Load())
+		}
+		if errorCount.Load() != 0 {
+			t.Errorf("expected no errors, got %d", errorCount.Load())
+		}
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		var requests atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer server.Close()
+
+		var status atomic.Int32
+		crawler := New(ctx, Config{
+			UserAgent:   "test",
+			RetryPolicy: BackoffRetryPolicy(3, time.Millisecond, 10*time.Millisecond),
+			ResponseHandler: func(_ string, resp *http.Response) error {
+				status.Store(int32(resp.StatusCode))
+				return nil
+			},
+		})
+
+		urls := func(yield func(string) bool) { yield(server.URL) }
+		if err := crawler.Run(ctx, urls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if requests.Load() != 3 {
+			t.Errorf("expected 3 requests, got %d", requests.Load())
+		}
+		if status.Load() != http.StatusBadGateway {
+			t.Errorf("expected handler to see final 502, got %d", status.Load())
+		}
+	})
+
+	t.Run("policy decisions", func(t *testing.T) {
+		policy := BackoffRetryPolicy(3, time.Second, 10*time.Second)
+
+		if retry, _ := policy("u", 1, &http.Response{StatusCode: http.StatusNotFound}, nil); retry {
+			t.Error("expected no retry for 404")
+		}
+		if retry, _ := policy("u", 3, nil, errors.New("reset")); retry {
+			t.Error("expected no retry after max attempts")
+		}
+		if retry, _ := policy("u", 1, nil, context.Canceled); retry {
+			t.Error("expected no retry for cancelled context")
+		}
+
+		resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"3"}}}
+		if retry, delay := policy("u", 1, resp, nil); !retry || delay != 3*time.Second {
+			t.Errorf("expected retry after 3s, got %v %v", retry, delay)
+		}
+
+		resp.Header.Set("Retry-After", "3600")
+		if retry, _ := policy("u", 1, resp, nil); retry {
+			t.Error("expected no retry when Retry-After exceeds max delay")
+		}
+
+		if retry, delay := policy("u", 2, nil, errors.New("reset")); !retry || delay < time.Second || delay > 2*time.Second {
+			t.Errorf("expected jittered backoff between 1s and 2s, got %v %v", retry, delay)
+		}
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{"Wed, 01 Jan 2025 12:00:30 GMT", 30 * time.Second, true},
+		{"Wed, 01 Jan 2025 11:00:00 GMT", 0, true},
+		{"", 0, false},
+		{"soon", 0, false},
+	}
+
+	for _, tt := range tests {
+		delay, ok := parseRetryAfter(tt.value, now)
+		if delay != tt.expected || ok != tt.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v; expected %v, %v", tt.value, delay, ok, tt.expected, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package crawl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRobotsTTL  = 24 * time.Hour
+	robotsErrorTTL    = time.Minute // for an unreachable robots.txt, so the origin is retried soon
+	maxRobotsBodySize = 500 << 10   // RFC 9309 requires parsing at least 500 KiB
+	minRobotsPrune    = 1000        // cache size below which expired entries are kept
+)
+
+// RobotsPolicy enables fetching and enforcing robots.txt.
+type RobotsPolicy struct {
+	// Agent is the product token matched against User-agent lines, e.g. "MyCrawler".
+	// Groups for "*" apply if no group matches. If empty, only "*" groups apply.
+	Agent string
+
+	// CacheTTL is how long a fetched robots.txt is used per origin. Default: 24h.
+	// An unreachable robots.txt is fetched again after a minute.
+	CacheTTL time.Duration
+}
+
+// RobotsError is reported to the ErrorHandler when a URL is skipped because of robots.txt.
+type RobotsError struct {
+	URL string
+
+	// Rule is the Disallow rule that matched. Empty if robots.txt was unreachable,
+	// in which case Err holds the cause and the whole origin is disallowed.
+	Rule string
+	Err  error
+}
+
+func (e *RobotsError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("robots.txt unreachable, skipping %s: %v", e.URL, e.Err)
+	}
+	return fmt.Sprintf("disallowed by robots.txt rule %q: %s", e.Rule, e.URL)
+}
+
+func (e *RobotsError) Unwrap() error {
+	return e.Err
+}
+
+// robotsRule is a single Allow or Disallow line.
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// robotsRules are the rules of the groups that apply to our agent.
+type robotsRules struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+	err        error // set if robots.txt was unreachable: everything is disallowed
+}
+
+// robotsEntry is a cached robots.txt for one origin.
+type robotsEntry struct {
+	ready   chan struct{}
+	rules   *robotsRules
+	expires time.Time
+}
+
+// robotsCache fetches and caches robots.txt per origin.
+type robotsCache struct {
+	policy   RobotsPolicy
+	client   *http.Client
+	ua       string
+	timeouts Timeouts // of Config.Timeouts, for the fetches
+
+	mu      sync.Mutex
+	entries map[string]*robotsEntry
+	pruneAt int // cache size at which expired entries are dropped
+}
+
+func newRobotsCache(policy RobotsPolicy, client *http.Client, userAgent string, timeouts Timeouts) *robotsCache {
+	if policy.CacheTTL <= 0 {
+		policy.CacheTTL = defaultRobotsTTL
+	}
+	return &robotsCache{
+		policy:   policy,
+		client:   client,
+		ua:       userAgent,
+		timeouts: timeouts,
+		entries:  make(map[string]*robotsEntry),
+		pruneAt:  minRobotsPrune,
+	}
+}
+
+// get returns the rules for the origin of u, fetching robots.txt if needed.
+// Concurrent callers for the same origin share a single fetch.
+func (rc *robotsCache) get(ctx context.Context, u *url.URL) *robotsRules {
+	origin := u.Scheme + "://" + u.Host
+
+	rc.mu.Lock()
+	e, ok := rc.entries[origin]
+	if ok {
+		select {
+		case <-e.ready:
+			if time.Now().After(e.expires) {
+				ok = false
+			}
+		default:
+		}
+	}
+	if !ok {
+		if len(rc.entries) >= rc.pruneAt {
+			rc.prune()
+		}
+		e = &robotsEntry{ready: make(chan struct{})}
+		rc.entries[origin] = e
+		rc.mu.Unlock()
+
+		e.rules = rc.fetch(ctx, origin)
+		ttl := rc.policy.CacheTTL
+		if e.rules.err != nil {
+			ttl = min(ttl, robotsErrorTTL)
+		}
+		e.expires = time.Now().Add(ttl)
+		close(e.ready)
+		return e.rules
+	}
+	rc.mu.Unlock()
+
+	select {
+	case <-e.ready:
+		return e.rules
+	case <-ctx.Done():
+		return &robotsRules{err: ctx.Err()}
+	}
+}
+
+// prune drops expired entries, and sets the size for the next prune. Must be called with mu held.
+func (rc *robotsCache) prune() {
+	now := time.Now()
+	for origin, e := range rc.entries {
+		select {
+		case <-e.ready:
+			if now.After(e.expires) {
+				delete(rc.entries, origin)
+			}
+		default:
+		}
+	}
+	rc.pruneAt = max(minRobotsPrune, 2*len(rc.entries))
+}
+
+// fetch downloads and parses robots.txt for an origin, within the Timeouts of the crawl.
+// As per RFC 9309, a 4xx response allows everything and
+// an unreachable robots.txt disallows everything.
+func (rc *robotsCache) fetch(ctx context.Context, origin string) *robotsRules {
+	var dl *deadlines
+	if rc.timeouts != (Timeouts{}) {
+		ctx, dl = withDeadlines(ctx, rc.timeouts)
+		defer dl.close()
+	}
+	fail := func(err error) *robotsRules {
+		if cause := timeoutCause(ctx); cause != nil {
+			err = cause
+		}
+		return &robotsRules{err: err}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", origin+"/robots.txt", nil)
+	if err != nil {
+		return &robotsRules{err: err}
+	}
+	req.Header.Set("User-Agent", rc.ua)
+
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		return fail(err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		if dl != nil {
+			dl.start(PhaseBodyRead, rc.timeouts.BodyRead)
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxRobotsBodySize))
+		if err != nil {
+			return fail(err)
+		}
+		return parseRobots(bytes.NewReader(body), rc.policy.Agent)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return &robotsRules{}
+	default:
+		return &robotsRules{err: fmt.Errorf("unexpected status %s", resp.Status)}
+	}
+}
+
+// parseRobots parses a robots.txt and returns the rules that apply to agent.
+// Groups naming agent take precedence over "*" groups, even if they have no rules;
+// multiple matching groups are merged.
+func parseRobots(r io.Reader, agent string) *robotsRules {
+	agent = strings.ToLower(agent)
+
+	var specific, wildcard robotsRules
+	matched := false           // true if a group names agent
+	var current []*robotsRules // groups the current lines apply to
+	inAgents := false          // true while reading consecutive User-agent lines
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		if key == "user-agent" {
+			if !inAgents {
+				current = nil
+				inAgents = true
+			}
+			token := strings.ToLower(value)
+			switch {
+			case token == "*":
+				current = append(current, &wildcard)
+			case agent != "" && token == agent:
+				current = append(current, &specific)
+				matched = true
+			}
+			continue
+		}
+		inAgents = false
+
+		for _, g := range current {
+			switch key {
+			case "allow", "disallow":
+				if value != "" {
+					g.rules = append(g.rules, robotsRule{allow: key == "allow", pattern: value})
+				}
+			case "crawl-delay":
+				if secs, err := strconv.ParseFloat(value, 64); err == nil && secs > 0 {
+					g.crawlDelay = time.Duration(secs * float64(time.Second))
+				}
+			}
+		}
+	}
+
+	if matched {
+		return &specific
+	}
+	return &wildcard
+}
+
+// check returns the Disallow rule that blocks u, if any.
+// The longest matching rule wins; Allow wins a tie.
+func (rr *robotsRules) check(u *url.URL) (rule string, allowed bool) {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+
+	best := -1
+	allowed = true
+	for _, r := range rr.rules {
+		if !robotsMatch(r.pattern, path) {
+			continue
+		}
+		if n := len(r.pattern); n > best || (n == best && r.allow) {
+			best = n
+			allowed = r.allow
+			rule = r.pattern
+		}
+	}
+	return rule, allowed
+}
+
+// robotsMatch matches a path against a robots.txt pattern, where
+// * matches any sequence of characters and a trailing $ anchors the end.
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+	for i, part := range parts[1:] {
+		if anchored && i == len(parts)-2 {
+			return len(path)-pos >= len(part) && strings.HasSuffix(path, part)
+		}
+		idx := strings.Index(path[pos:], part)
+		if idx < 0 {
+			return false
+		}
+		pos += idx + len(part)
+	}
+	return !anchored || pos == len(path)
+}
+
+// checkRobots returns a RobotsError if robots.txt disallows u.
+// It also tells the scheduler that the rules of the host are known, with their Crawl-delay.
+func (c *Crawler) checkRobots(ctx context.Context, sched *scheduler, t *task, u *url.URL) error {
+	rules := c.robots.get(ctx, u)
+	sched.setCrawlDelay(t.host, rules.crawlDelay)
+	if rules.err != nil {
+		return &RobotsError{URL: u.String(), Err: rules.err}
+	}
+	if rule, allowed := rules.check(u); !allowed {
+		return &RobotsError{URL: u.String(), Rule: rule}
+	}
+	return nil
+}
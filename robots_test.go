@@ -0,0 +1,265 @@
+package crawl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testRobots = `# comment
+User-agent: *
+Disallow: /admin
+Allow: /admin/public
+Disallow: /*.php$
+Crawl-delay: 0.5
+
+User-agent: MyCrawler
+User-agent: OtherBot
+Disallow: /private
+`
+
+func TestParseRobots(t *testing.T) {
+	tests := []struct {
+		agent   string
+		path    string
+		allowed bool
+	}{
+		{"", "/", true},
+		{"", "/admin", false},
+		{"", "/admin/users", false},
+		{"", "/admin/public/logo.png", true},
+		{"", "/index.php", false},
+		{"", "/index.php?x=1", true},
+		{"", "/private", true},
+		{"mycrawler", "/private/x", false},
+		{"MyCrawler", "/admin", true},
+		{"UnknownBot", "/admin", false},
+	}
+
+	for _, tt := range tests {
+		rules := parseRobots(strings.NewReader(testRobots), tt.agent)
+		u, _ := url.Parse("https://example.com" + tt.path)
+		if _, allowed := rules.check(u); allowed != tt.allowed {
+			t.Errorf("agent %q path %q: expected allowed=%v", tt.agent, tt.path, tt.allowed)
+		}
+	}
+
+	// An empty Disallow in a group naming the agent allows everything, whatever "*" says
+	rules := parseRobots(strings.NewReader("User-agent: *\nDisallow: /\n\nUser-agent: MyBot\nDisallow:\n"), "MyBot")
+	if rule, allowed := rules.check(&url.URL{Path: "/page"}); !allowed {
+		t.Errorf("expected /page to be allowed for MyBot, got disallowed by %q", rule)
+	}
+
+	if rules := parseRobots(strings.NewReader(testRobots), ""); rules.crawlDelay != 500*time.Millisecond {
+		t.Errorf("expected crawl-delay 500ms, got %v", rules.crawlDelay)
+	}
+}
+
+func TestRobotsMatch(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		match         bool
+	}{
+		{"/", "/anything", true},
+		{"/fish", "/fish.html", true},
+		{"/fish", "/Fish", false},
+		{"/fish*.php", "/fish/salmon.php", true},
+		{"/*.php$", "/filename.php", true},
+		{"/*.php$", "/filename.php/", false},
+		{"/fish$", "/fish", true},
+		{"/fish$", "/fishes", false},
+	}
+
+	for _, tt := range tests {
+		if got := robotsMatch(tt.pattern, tt.path); got != tt.match {
+			t.Errorf("robotsMatch(%q, %q) = %v, expected %v", tt.pattern, tt.path, got, tt.match)
+		}
+	}
+}
+
+func TestCrawlerRobots(t *testing.T) {
+	ctx := context.Background()
+
+	var robotsFetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			robotsFetches.Add(1)
+			w.Write([]byte("User-agent: *\nDisallow: /admin\n")) //nolint:errcheck
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	var mu sync.Mutex
+	var handled []string
+	var robotsErrors int
+	crawler := New(ctx, Config{
+		WorkerCount: 3,
+		UserAgent:   "test",
+		Robots:      &RobotsPolicy{},
+		ResponseHandler: func(u string, resp *http.Response) error {
+			mu.Lock()
+			defer mu.Unlock()
+			handled = append(handled, u)
+			return nil
+		},
+		ErrorHandler: func(u string, err error) {
+			var robotsErr *RobotsError
+			if errors.As(err, &robotsErr) {
+				mu.Lock()
+				defer mu.Unlock()
+				robotsErrors++
+			}
+		},
+	})
+
+	urls := func(yield func(string) bool) {
+		for _, path := range []string{"/", "/admin", "/shop", "/admin/login"} {
+			if !yield(server.URL + path) {
+				return
+			}
+		}
+	}
+
+	if err := crawler.Run(ctx, urls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(handled) != 2 {
+		t.Errorf("expected 2 handled URLs, got %v", handled)
+	}
+	if robotsErrors != 2 {
+		t.Errorf("expected 2 robots errors, got %d", robotsErrors)
+	}
+	if robotsFetches.Load() != 1 {
+		t.Errorf("expected robots.txt to be fetched once, got %d", robotsFetches.Load())
+	}
+}
+
+func TestCrawlerRobotsCrawlDelay(t *testing.T) {
+	ctx := context.Background()
+
+	const delay = 200 * time.Millisecond
+	var mu sync.Mutex
+	var arrivals []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.Write([]byte("User-agent: *\nCrawl-delay: 0.2\n")) //nolint:errcheck
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		arrivals = append(arrivals, time.Now())
+	}))
+	defer server.Close()
+
+	crawler := New(ctx, Config{
+		WorkerCount:     5,
+		UserAgent:       "test",
+		Robots:          &RobotsPolicy{},
+		ResponseHandler: func(u string, resp *http.Response) error { return nil },
+	})
+
+	urls := func(yield func(string) bool) {
+		for _, path := range []string{"/a", "/b", "/c", "/d", "/e"} {
+			if !yield(server.URL + path) {
+				return
+			}
+		}
+	}
+	if err := crawler.Run(ctx, urls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(arrivals) != 5 {
+		t.Fatalf("expected 5 requests, got %d", len(arrivals))
+	}
+	// Allow for the request starting just before the server sees it
+	for i := 1; i < len(arrivals); i++ {
+		if gap := arrivals[i].Sub(arrivals[i-1]); gap < delay-20*time.Millisecond {
+			t.Errorf("expected requests %v apart, request %d came after %v", delay, i+1, gap)
+		}
+	}
+}
+
+func TestRobotsCacheUnreachable(t *testing.T) {
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	rc := newRobotsCache(RobotsPolicy{}, server.Client(), "test", Timeouts{})
+	u, _ := url.Parse(server.URL + "/page")
+	if rules := rc.get(context.Background(), u); rules.err == nil {
+		t.Fatal("expected an unreachable robots.txt")
+	}
+
+	// Cached briefly rather than for the CacheTTL
+	e := rc.entries[u.Scheme+"://"+u.Host]
+	if ttl := time.Until(e.expires); ttl > robotsErrorTTL {
+		t.Errorf("expected the failure to be cached for at most %v, got %v", robotsErrorTTL, ttl)
+	}
+	rc.get(context.Background(), u)
+	if fetches.Load() != 1 {
+		t.Errorf("expected 1 fetch within the TTL, got %d", fetches.Load())
+	}
+	e.expires = time.Now()
+	rc.get(context.Background(), u)
+	if fetches.Load() != 2 {
+		t.Errorf("expected a refetch after the TTL, got %d fetches", fetches.Load())
+	}
+}
+
+func TestRobotsCacheTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release // a tarpit
+	}))
+	defer server.Close()
+	defer close(release)
+
+	rc := newRobotsCache(RobotsPolicy{}, server.Client(), "test", Timeouts{ResponseHeader: 50 * time.Millisecond})
+	u, _ := url.Parse(server.URL + "/page")
+	rules := rc.get(context.Background(), u)
+	var timeoutErr *TimeoutError
+	if !errors.As(rules.err, &timeoutErr) || timeoutErr.Phase != PhaseResponseHeader {
+		t.Errorf("expected a response header timeout, got %v", rules.err)
+	}
+}
+
+func TestRobotsCachePrune(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	rc := newRobotsCache(RobotsPolicy{}, server.Client(), "test", Timeouts{})
+	u, _ := url.Parse(server.URL + "/page")
+	rc.get(context.Background(), u)
+	rc.entries[u.Scheme+"://"+u.Host].expires = time.Now()
+
+	// Fill the cache up to the prune size with origins that are still fresh
+	for i := len(rc.entries); i < minRobotsPrune; i++ {
+		e := &robotsEntry{ready: make(chan struct{}), rules: &robotsRules{}, expires: time.Now().Add(time.Hour)}
+		close(e.ready)
+		rc.entries[fmt.Sprintf("https://%d.example", i)] = e
+	}
+
+	other, _ := url.Parse(strings.Replace(server.URL, "127.0.0.1", "localhost", 1) + "/page")
+	rc.get(context.Background(), other)
+	if _, ok := rc.entries[u.Scheme+"://"+u.Host]; ok {
+		t.Error("expected the expired origin to be pruned")
+	}
+	if len(rc.entries) != minRobotsPrune {
+		t.Errorf("expected the fresh origins to be kept, got %d entries", len(rc.entries))
+	}
+}
@@ -0,0 +1,325 @@
+package crawl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SaverLayout determines where a BodySaver stores response bodies.
+type SaverLayout int
+
+const (
+	// SaverMirror mirrors the final URL: <dir>/<host>[_<port>]/<path>. Paths ending in a
+	// slash are saved as index.html, and a query adds a hash of it to the file name.
+	// A path that also has paths below it, such as /docs next to /docs/intro, is saved
+	// as index.html in its directory.
+	SaverMirror SaverLayout = iota
+	// SaverHash names files by a SHA-256 hash of the URL: <dir>/<ab>/<hash>, with a
+	// <hash>.json sidecar holding the URLs, status, headers and result metadata.
+	SaverHash
+	// SaverDate mirrors the final URL below the fetch date: <dir>/<YYYY-MM-DD>/<host>/<path>.
+	SaverDate
+)
+
+// SaverOptions configures a BodySaver.
+type SaverOptions struct {
+	// Layout determines the file names. Default: SaverMirror.
+	Layout SaverLayout
+
+	// Gzip compresses bodies on disk and adds .gz to the file names.
+	Gzip bool
+
+	// Overwrite replaces existing files. By default, a body that would replace an existing
+	// file is saved next to it with a numeric suffix, e.g. index.html~1.
+	Overwrite bool
+}
+
+// BodySaver saves response bodies to files. Files are written to a temporary file
+// first and renamed into place, so readers never see a partial file.
+// It is safe for concurrent use.
+type BodySaver struct {
+	dir  string
+	opts SaverOptions
+	now  func() time.Time
+
+	mu sync.Mutex // serializes placing files, see place
+}
+
+// NewBodySaver creates a BodySaver that saves bodies below dir.
+func NewBodySaver(dir string, opts SaverOptions) (*BodySaver, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	return &BodySaver{dir: dir, opts: opts, now: time.Now}, nil
+}
+
+// Handle is a ResponseHandler that saves the response body. The body is read completely.
+func (s *BodySaver) Handle(url string, resp *http.Response) error {
+	return s.save(&Result{URL: url, Response: resp})
+}
+
+// HandleResult is a ResultHandler that saves the response body. With SaverHash,
+// the sidecar includes the redirect chain and connection details of the result.
+func (s *BodySaver) HandleResult(res *Result) error {
+	return s.save(res)
+}
+
+// save writes the body of res, and with SaverHash its sidecar.
+func (s *BodySaver) save(res *Result) error {
+	resp := res.Response
+	finalURL := res.URL
+	if resp.Request != nil && resp.Request.URL != nil {
+		finalURL = resp.Request.URL.String()
+	}
+	fetched := res.Started
+	if fetched.IsZero() {
+		fetched = s.now()
+	}
+
+	name, err := s.fileName(res.URL, finalURL, fetched)
+	if err != nil {
+		return err
+	}
+	if s.opts.Gzip {
+		name += ".gz"
+	}
+	target := filepath.Join(s.dir, name)
+
+	tmp, err := writeTemp(s.dir, resp.Body, s.opts.Gzip)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	target, err = s.place(target)
+	if err == nil {
+		target, err = s.rename(tmp, target)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		os.Remove(tmp) //nolint:errcheck
+		return err
+	}
+
+	if s.opts.Layout == SaverHash {
+		return s.writeSidecar(strings.TrimSuffix(target, ".gz")+".json", res, finalURL, fetched)
+	}
+	return nil
+}
+
+// fileName returns the path of the body relative to the saver's directory.
+func (s *BodySaver) fileName(rawURL, finalURL string, fetched time.Time) (string, error) {
+	if s.opts.Layout == SaverHash {
+		sum := sha256.Sum256([]byte(rawURL))
+		hash := hex.EncodeToString(sum[:])
+		return filepath.Join(hash[:2], hash), nil
+	}
+
+	u, err := url.Parse(finalURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse URL %s: %w", finalURL, err)
+	}
+	// The host becomes a directory name, so it must not lead out of the directory
+	if host := u.Hostname(); host == "." || host == ".." || strings.ContainsAny(host, `/\`) {
+		return "", fmt.Errorf("invalid host %q in URL %s", host, finalURL)
+	}
+	name := mirrorName(u)
+	if s.opts.Layout == SaverDate {
+		name = filepath.Join(fetched.UTC().Format(time.DateOnly), name)
+	}
+	return name, nil
+}
+
+// mirrorName maps a URL to a relative file path: <host>[_<port>]/<path>.
+func mirrorName(u *url.URL) string {
+	host := u.Hostname()
+	if port := u.Port(); port != "" {
+		host += "_" + port
+	}
+
+	// Cleaning a rooted path removes any .. that would escape the directory
+	p := path.Clean("/" + u.EscapedPath())
+	if p == "/" || strings.HasSuffix(u.EscapedPath(), "/") {
+		p = path.Join(p, "index.html")
+	}
+	if u.RawQuery != "" {
+		sum := sha256.Sum256([]byte(u.RawQuery))
+		p += "_" + hex.EncodeToString(sum[:4])
+	}
+	return filepath.Join(host, filepath.FromSlash(p))
+}
+
+// writeTemp writes body, optionally gzipped, to a temporary file in dir and returns its name.
+func writeTemp(dir string, body io.Reader, compress bool) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	file, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create file in %s: %w", dir, err)
+	}
+
+	var w io.Writer = file
+	var zw *gzip.Writer
+	if compress {
+		zw = gzip.NewWriter(file)
+		w = zw
+	}
+	_, err = io.Copy(w, body)
+	if err == nil && zw != nil {
+		err = zw.Close()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(file.Name()) //nolint:errcheck
+		return "", fmt.Errorf("failed to write %s: %w", file.Name(), err)
+	}
+	return file.Name(), nil
+}
+
+// place creates the directory of target, resolving conflicts between files and
+// directories of the mirror layouts: a file where a directory is needed is moved to
+// index.html inside it, and if target is a directory, index.html inside it is used.
+// It returns the name to use. Must be called with mu held.
+func (s *BodySaver) place(target string) (string, error) {
+	rel, err := filepath.Rel(s.dir, filepath.Dir(target))
+	if err != nil {
+		return "", fmt.Errorf("failed to place %s: %w", target, err)
+	}
+	dir := s.dir
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, part)
+		if info, err := os.Lstat(dir); err == nil && !info.IsDir() {
+			if err := s.moveIntoDir(dir); err != nil {
+				return "", err
+			}
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create directory %s: %w", filepath.Dir(target), err)
+	}
+
+	if info, err := os.Lstat(target); err == nil && info.IsDir() {
+		name := "index.html"
+		if s.opts.Gzip {
+			name += ".gz"
+		}
+		target = filepath.Join(target, name)
+	}
+	return target, nil
+}
+
+// moveIntoDir replaces the file name by a directory with the file as its index.html.
+func (s *BodySaver) moveIntoDir(name string) error {
+	tmp := name + ".tmp-dir"
+	if err := os.Rename(name, tmp); err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %w", name, tmp, err)
+	}
+	if err := os.Mkdir(name, 0o755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", name, err)
+	}
+	index := filepath.Join(name, "index.html")
+	if err := os.Rename(tmp, index); err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %w", tmp, index, err)
+	}
+	return nil
+}
+
+// rename moves tmp to target. Unless overwriting, an existing target is kept and
+// a numeric suffix is added, and it returns the name that was used.
+func (s *BodySaver) rename(tmp, target string) (string, error) {
+	if s.opts.Overwrite {
+		if err := os.Rename(tmp, target); err != nil {
+			return "", fmt.Errorf("failed to rename %s to %s: %w", tmp, target, err)
+		}
+		return target, nil
+	}
+
+	ext := ""
+	if s.opts.Gzip {
+		ext = ".gz"
+	}
+	base := strings.TrimSuffix(target, ext)
+	for i := 0; ; i++ {
+		name := target
+		if i > 0 {
+			name = base + "~" + strconv.Itoa(i) + ext
+		}
+		// Linking fails if the name exists, so concurrent saves cannot replace each other
+		err := os.Link(tmp, name)
+		if err == nil {
+			os.Remove(tmp) //nolint:errcheck
+			return name, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return "", fmt.Errorf("failed to rename %s to %s: %w", tmp, name, err)
+		}
+	}
+}
+
+// savedMeta is the JSON sidecar of a body saved with SaverHash.
+type savedMeta struct {
+	URL             string      `json:"url"`
+	FinalURL        string      `json:"finalURL"`
+	Fetched         time.Time   `json:"fetched"`
+	Status          int         `json:"status"`
+	Header          http.Header `json:"headers"`
+	Redirects       []string    `json:"redirects,omitempty"`
+	RemoteAddr      string      `json:"remoteAddr,omitempty"`
+	Protocol        string      `json:"protocol,omitempty"`
+	ContentEncoding string      `json:"contentEncoding,omitempty"`
+	Charset         string      `json:"charset,omitempty"`
+	Truncated       bool        `json:"truncated,omitempty"`
+	Gzip            bool        `json:"gzip,omitempty"`
+}
+
+// writeSidecar atomically writes the metadata of res to name.
+func (s *BodySaver) writeSidecar(name string, res *Result, finalURL string, fetched time.Time) error {
+	meta := savedMeta{
+		URL:             res.URL,
+		FinalURL:        finalURL,
+		Fetched:         fetched.UTC(),
+		Status:          res.Response.StatusCode,
+		Header:          res.Response.Header,
+		RemoteAddr:      res.RemoteAddr,
+		Protocol:        res.Protocol,
+		ContentEncoding: res.ContentEncoding,
+		Charset:         res.Charset,
+		Truncated:       res.Truncated,
+		Gzip:            s.opts.Gzip,
+	}
+	for _, hop := range res.Redirects {
+		meta.Redirects = append(meta.Redirects, hop.URL)
+	}
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata for %s: %w", res.URL, err)
+	}
+	tmp, err := writeTemp(filepath.Dir(name), bytes.NewReader(append(data, '\n')), false)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp) //nolint:errcheck
+		return fmt.Errorf("failed to rename %s to %s: %w", tmp, name, err)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package crawl
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// saveResponse saves body as the response to rawURL, after a redirect if finalURL differs.
+func saveResponse(t *testing.T, s *BodySaver, rawURL, finalURL, body string) {
+	t.Helper()
+	u, _ := url.Parse(finalURL)
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/html"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    &http.Request{URL: u},
+	}
+	res := &Result{URL: rawURL, Response: resp}
+	if rawURL != finalURL {
+		res.Redirects = []Redirect{{URL: rawURL, StatusCode: http.StatusFound}}
+	}
+	if err := s.HandleResult(res); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+// readFile returns the content of a file below dir, or "" if it does not exist.
+func readFile(t *testing.T, dir, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func TestBodySaverMirror(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewBodySaver(dir, SaverOptions{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	saveResponse(t, s, "https://example.com", "https://example.com/", "home")
+	saveResponse(t, s, "https://example.com/a/b.html", "https://example.com/a/b.html", "b")
+	saveResponse(t, s, "http://example.com:8080/a/", "http://example.com:8080/a/", "port")
+	saveResponse(t, s, "https://example.com/search?q=1", "https://example.com/search?q=1", "q1")
+	saveResponse(t, s, "https://example.com/search?q=2", "https://example.com/search?q=2", "q2")
+	saveResponse(t, s, "https://old.example/", "https://new.example/x/../../../etc/passwd", "moved")
+	saveResponse(t, s, "https://example.com/a/b.html", "https://example.com/a/b.html", "b again")
+
+	tests := map[string]string{
+		"example.com/index.html":        "home",
+		"example.com/a/b.html":          "b",
+		"example.com/a/b.html~1":        "b again",
+		"example.com_8080/a/index.html": "port",
+		"new.example/etc/passwd":        "moved",
+	}
+	for name, expected := range tests {
+		if got := readFile(t, dir, name); got != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, got)
+		}
+	}
+
+	queries, _ := filepath.Glob(filepath.Join(dir, "example.com", "search_*"))
+	if len(queries) != 2 {
+		t.Errorf("expected 2 files for different queries, got %v", queries)
+	}
+	if temps, _ := filepath.Glob(filepath.Join(dir, ".tmp-*")); len(temps) > 0 {
+		t.Errorf("expected no temporary files, got %v", temps)
+	}
+}
+
+func TestBodySaverConflicts(t *testing.T) {
+	// A path with paths below it is stored as index.html in its directory, in either order
+	orders := [][]string{
+		{"https://example.com/test", "https://example.com/test/foo"},
+		{"https://example.com/test/foo", "https://example.com/test"},
+	}
+	for _, urls := range orders {
+		dir := t.TempDir()
+		s, _ := NewBodySaver(dir, SaverOptions{})
+		for _, u := range urls {
+			saveResponse(t, s, u, u, u)
+		}
+		saveResponse(t, s, "https://example.com/test/foo/bar", "https://example.com/test/foo/bar", "bar")
+
+		tests := map[string]string{
+			"example.com/test/index.html":     "https://example.com/test",
+			"example.com/test/foo/index.html": "https://example.com/test/foo",
+			"example.com/test/foo/bar":        "bar",
+		}
+		for name, expected := range tests {
+			if got := readFile(t, dir, name); got != expected {
+				t.Errorf("%v: %s: expected %q, got %q", urls, name, expected, got)
+			}
+		}
+	}
+}
+
+func TestBodySaverHash(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewBodySaver(dir, SaverOptions{Layout: SaverHash, Gzip: true})
+
+	saveResponse(t, s, "https://example.com/old", "https://example.com/new", "hello")
+
+	bodies, _ := filepath.Glob(filepath.Join(dir, "*", "*.gz"))
+	if len(bodies) != 1 {
+		t.Fatalf("expected a single body, got %v", bodies)
+	}
+	f, _ := os.Open(bodies[0])
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("expected a gzipped body, got %v", err)
+	}
+	if data, _ := io.ReadAll(zr); string(data) != "hello" {
+		t.Errorf("expected hello, got %q", data)
+	}
+
+	data, err := os.ReadFile(strings.TrimSuffix(bodies[0], ".gz") + ".json")
+	if err != nil {
+		t.Fatalf("expected a sidecar, got %v", err)
+	}
+	var meta savedMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatalf("expected valid JSON, got %v", err)
+	}
+	if meta.URL != "https://example.com/old" || meta.FinalURL != "https://example.com/new" || meta.Status != http.StatusOK ||
+		meta.Header.Get("Content-Type") != "text/html" || len(meta.Redirects) != 1 || !meta.Gzip {
+		t.Errorf("unexpected sidecar: %+v", meta)
+	}
+}
+
+func TestBodySaverDate(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewBodySaver(dir, SaverOptions{Layout: SaverDate})
+	s.now = func() time.Time { return time.Date(2026, 3, 4, 23, 0, 0, 0, time.UTC) }
+
+	saveResponse(t, s, "https://example.com/page", "https://example.com/page", "page")
+	if got := readFile(t, dir, "2026-03-04/example.com/page"); got != "page" {
+		t.Errorf("expected page below the date, got %q", got)
+	}
+}
+
+func TestBodySaverConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewBodySaver(dir, SaverOptions{})
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			resp := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("same"))}
+			if err := s.Handle("https://example.com/", resp); err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		})
+	}
+	wg.Wait()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "example.com", "index.html*"))
+	if len(files) != 10 {
+		t.Errorf("expected 10 files without overwrites, got %d", len(files))
+	}
+}
+
+func TestBodySaverInvalidHost(t *testing.T) {
+	for _, layout := range []SaverLayout{SaverMirror, SaverDate} {
+		dir := filepath.Join(t.TempDir(), "out")
+		s, err := NewBodySaver(dir, SaverOptions{Layout: layout})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for _, rawURL := range []string{"http://../../escape", "http://./x", `http://a\..\..\escape/`} {
+			u, err := url.Parse(rawURL)
+			if err != nil {
+				continue
+			}
+			resp := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("x")), Request: &http.Request{URL: u}}
+			if err := s.HandleResult(&Result{URL: rawURL, Response: resp}); err == nil {
+				t.Errorf("layout %d: expected %s to be rejected", layout, rawURL)
+			}
+		}
+		if escaped, _ := filepath.Glob(filepath.Join(filepath.Dir(dir), "escape*")); len(escaped) > 0 {
+			t.Errorf("expected no files outside the directory, got %v", escaped)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package crawl
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// task is a single unit of work handed to a worker.
+type task struct {
+	url       string
+	host      string
+	attempt   int       // 1 for the first try
+	notBefore time.Time // set for delayed retries
+	depth     int       // number of links followed from the seed URL
+	origin    string    // hostname of the seed URL
+	meta      any       // metadata of the item
+}
+
+// hostState tracks the queued tasks and politeness state of a single host.
+type hostState struct {
+	tasks    []*task
+	inflight int
+	last     time.Time     // start of the last request to this host
+	delay    time.Duration // per-host delay, e.g. from robots.txt Crawl-delay
+	robots   robotsState
+	prober   *task // the task fetching robots.txt while robotsFetching
+}
+
+// robotsState tracks whether the robots.txt rules of a host are known. With robots.txt
+// enabled, a host hands out a single task until they are, so its Crawl-delay paces the rest.
+type robotsState int
+
+const (
+	robotsUnknown robotsState = iota
+	robotsFetching
+	robotsKnown
+)
+
+// ready returns the earliest time the next request to this host may start.
+func (h *hostState) ready(delay time.Duration) time.Time {
+	return h.last.Add(max(delay, h.delay))
+}
+
+// scheduler hands out tasks to workers while enforcing a per-host
+// concurrency limit and a minimum delay between requests to the same host.
+// Workers pick the first task whose host is eligible, so a busy host
+// does not block the others.
+type scheduler struct {
+	maxPerHost int
+	delay      time.Duration
+	capacity   int
+	robots     bool // hosts wait for their robots.txt rules, see robotsState
+
+	mu       sync.Mutex
+	wake     chan struct{}
+	hosts    map[string]*hostState
+	active   []*hostState // hosts with queued tasks, in order of arrival
+	queued   int
+	inflight int
+	closed   bool
+}
+
+func newScheduler(config Config) *scheduler {
+	return &scheduler{
+		maxPerHost: config.MaxPerHost,
+		delay:      config.HostDelay,
+		capacity:   config.QueueSize,
+		robots:     config.Robots != nil,
+		wake:       make(chan struct{}),
+		hosts:      make(map[string]*hostState),
+	}
+}
+
+// hostKey returns the key used to group URLs per host.
+func hostKey(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// broadcast wakes up all goroutines waiting on the scheduler. Must be called with mu held.
+func (s *scheduler) broadcast() {
+	close(s.wake)
+	s.wake = make(chan struct{})
+}
+
+// push queues a task from the URL generator, blocking while the queue is full.
+// It returns false if ctx is done before the task could be queued.
+func (s *scheduler) push(ctx context.Context, t *task) bool {
+	s.mu.Lock()
+	for s.queued >= s.capacity {
+		wake := s.wake
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-wake:
+		}
+		s.mu.Lock()
+	}
+	s.enqueue(t)
+	s.mu.Unlock()
+	return true
+}
+
+// enqueue adds a task to its host queue. Must be called with mu held.
+func (s *scheduler) enqueue(t *task) {
+	t.host = hostKey(t.url)
+	if t.origin == "" {
+		t.origin = t.host
+	}
+
+	h, ok := s.hosts[t.host]
+	if !ok {
+		if len(s.hosts) > 2*s.capacity {
+			s.prune(time.Now())
+		}
+		h = &hostState{}
+		s.hosts[t.host] = h
+	}
+	if len(h.tasks) == 0 {
+		s.active = append(s.active, h)
+	}
+	h.tasks = append(h.tasks, t)
+	s.queued++
+	s.broadcast()
+}
+
+// add queues a task from a worker, such as a discovered link. Unlike push,
+// it never blocks, so a worker can add tasks while the queue is full.
+func (s *scheduler) add(t *task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.enqueue(t)
+}
+
+// retry puts a task that is currently in flight back into its host queue,
+// to be handed out again after delay.
+func (s *scheduler) retry(t *task, delay time.Duration) {
+	t.notBefore = time.Now().Add(delay)
+	s.add(t)
+}
+
+// prune forgets idle hosts whose delay has passed. Must be called with mu held.
+func (s *scheduler) prune(now time.Time) {
+	for name, h := range s.hosts {
+		if len(h.tasks) == 0 && h.inflight == 0 && !now.Before(h.ready(s.delay)) {
+			delete(s.hosts, name)
+		}
+	}
+}
+
+// close marks the end of the input. Workers return once all queued
+// and in-flight tasks are done.
+func (s *scheduler) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.broadcast()
+	s.mu.Unlock()
+}
+
+// next blocks until a task is eligible to run and returns it.
+// It returns false when all work is done or ctx is cancelled.
+func (s *scheduler) next(ctx context.Context) (*task, bool) {
+	for {
+		s.mu.Lock()
+		t, wait := s.pick(time.Now())
+		if t != nil {
+			s.mu.Unlock()
+			return t, true
+		}
+		if s.closed && s.queued == 0 && s.inflight == 0 {
+			s.mu.Unlock()
+			return nil, false
+		}
+		wake := s.wake
+		s.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			stopTimer(timer)
+			return nil, false
+		case <-wake:
+		case <-timeout:
+		}
+		stopTimer(timer)
+	}
+}
+
+// pick removes and returns the first task whose host is eligible.
+// If none is eligible, it returns how long to wait before a host or a
+// delayed task becomes eligible (0 if waiting on another worker).
+// Must be called with mu held.
+func (s *scheduler) pick(now time.Time) (*task, time.Duration) {
+	var wait time.Duration
+	for i, h := range s.active {
+		if s.maxPerHost > 0 && h.inflight >= s.maxPerHost {
+			continue
+		}
+		if s.robots && h.robots == robotsFetching {
+			continue
+		}
+		if ready := h.ready(s.delay); now.Before(ready) {
+			wait = shorter(wait, ready.Sub(now))
+			continue
+		}
+
+		idx := -1
+		for j, t := range h.tasks {
+			if !now.Before(t.notBefore) {
+				idx = j
+				break
+			}
+			wait = shorter(wait, t.notBefore.Sub(now))
+		}
+		if idx < 0 {
+			continue
+		}
+
+		t := h.tasks[idx]
+		h.tasks = append(h.tasks[:idx], h.tasks[idx+1:]...)
+		if len(h.tasks) == 0 {
+			s.active = append(s.active[:i], s.active[i+1:]...)
+		}
+		h.inflight++
+		h.last = now
+		if s.robots && h.robots == robotsUnknown {
+			h.robots = robotsFetching
+			h.prober = t
+		}
+		s.queued--
+		s.inflight++
+		s.broadcast()
+		return t, 0
+	}
+	return nil, wait
+}
+
+// shorter returns the smaller non-zero duration of wait and d.
+func shorter(wait, d time.Duration) time.Duration {
+	if wait == 0 || d < wait {
+		return d
+	}
+	return wait
+}
+
+// setCrawlDelay records that the robots.txt rules of host are known, and raises the
+// minimum delay between requests to host to their Crawl-delay.
+func (s *scheduler) setCrawlDelay(host string, delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.hosts[host]
+	if !ok {
+		return
+	}
+	if delay > h.delay {
+		h.delay = delay
+	}
+	if h.robots != robotsKnown {
+		// The request of the prober starts now, so the delay counts from here
+		h.robots = robotsKnown
+		h.prober = nil
+		h.last = time.Now()
+		s.broadcast()
+	}
+}
+
+// depth returns the number of queued tasks.
+func (s *scheduler) depth() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.queued
+}
+
+// done marks a task handed out by next as finished.
+func (s *scheduler) done(t *task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if h, ok := s.hosts[t.host]; ok {
+		if h.robots == robotsFetching && h.prober == t {
+			// Finished before robots.txt was checked, e.g. the RequestBuilder failed
+			h.robots = robotsUnknown
+			h.prober = nil
+		}
+		h.inflight--
+		if len(h.tasks) == 0 && h.inflight == 0 && h.delay == 0 && !time.Now().Before(h.ready(s.delay)) {
+			delete(s.hosts, t.host)
+		}
+	}
+	s.inflight--
+	s.broadcast()
+}
+
+func stopTimer(t *time.Timer) {
+	if t != nil {
+		t.Stop()
+	}
+}
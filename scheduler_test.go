@@ -0,0 +1,143 @@
+package crawl
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("skips busy host", func(t *testing.T) {
+		s := newScheduler(Config{MaxPerHost: 1, QueueSize: 10})
+		for _, u := range []string{"https://a.example/1", "https://a.example/2", "https://b.example/1"} {
+			s.push(ctx, &task{url: u})
+		}
+
+		first, _ := s.next(ctx)
+		second, _ := s.next(ctx)
+		if first.url != "https://a.example/1" || second.url != "https://b.example/1" {
+			t.Fatalf("expected a.example/1 then b.example/1, got %s then %s", first.url, second.url)
+		}
+
+		s.done(first)
+		third, _ := s.next(ctx)
+		if third.url != "https://a.example/2" {
+			t.Errorf("expected a.example/2 after host became free, got %s", third.url)
+		}
+	})
+
+	t.Run("waits for robots.txt", func(t *testing.T) {
+		s := newScheduler(Config{QueueSize: 10, Robots: &RobotsPolicy{}})
+		for _, u := range []string{"https://a.example/1", "https://a.example/2", "https://a.example/3"} {
+			s.push(ctx, &task{url: u})
+		}
+
+		// One task fetches robots.txt, the others wait for its rules
+		prober, _ := s.next(ctx)
+		if tk, _ := s.pick(time.Now()); tk != nil {
+			t.Fatalf("expected the host to wait for robots.txt, got %s", tk.url)
+		}
+
+		// A prober that ends before robots.txt was checked hands over to the next task
+		s.done(prober)
+		prober, _ = s.next(ctx)
+		if prober.url != "https://a.example/2" {
+			t.Fatalf("expected a.example/2 to fetch robots.txt, got %s", prober.url)
+		}
+
+		s.setCrawlDelay("a.example", time.Hour)
+		if tk, wait := s.pick(time.Now()); tk != nil || wait < time.Hour-time.Minute {
+			t.Errorf("expected the Crawl-delay to apply, got %v and a wait of %v", tk, wait)
+		}
+	})
+
+	t.Run("returns when input is exhausted", func(t *testing.T) {
+		s := newScheduler(Config{QueueSize: 10})
+		s.push(ctx, &task{url: "https://a.example/"})
+		s.close()
+
+		tk, ok := s.next(ctx)
+		if !ok {
+			t.Fatal("expected a task")
+		}
+		s.done(tk)
+		if _, ok := s.next(ctx); ok {
+			t.Error("expected no more tasks")
+		}
+	})
+}
+
+func TestCrawlerPoliteness(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("max per host", func(t *testing.T) {
+		var current, peak atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := current.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			current.Add(-1)
+		}))
+		defer server.Close()
+
+		crawler := New(ctx, Config{
+			WorkerCount:     5,
+			MaxPerHost:      2,
+			UserAgent:       "test",
+			ResponseHandler: func(string, *http.Response) error { return nil },
+		})
+
+		urls := func(yield func(string) bool) {
+			for i := 0; i < 10; i++ {
+				if !yield(server.URL) {
+					return
+				}
+			}
+		}
+
+		if err := crawler.Run(ctx, urls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if peak.Load() > 2 {
+			t.Errorf("expected at most 2 concurrent requests, got %d", peak.Load())
+		}
+	})
+
+	t.Run("host delay", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer server.Close()
+
+		crawler := New(ctx, Config{
+			WorkerCount:     3,
+			HostDelay:       50 * time.Millisecond,
+			UserAgent:       "test",
+			ResponseHandler: func(string, *http.Response) error { return nil },
+		})
+
+		urls := func(yield func(string) bool) {
+			for i := 0; i < 3; i++ {
+				if !yield(server.URL) {
+					return
+				}
+			}
+		}
+
+		start := time.Now()
+		if err := crawler.Run(ctx, urls); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Errorf("expected at least 100ms for 3 delayed requests, took %v", elapsed)
+		}
+	})
+}
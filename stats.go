@@ -0,0 +1,215 @@
+package crawl
+
+import (
+	"context"
+	"maps"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats summarizes a crawl.
+type Stats struct {
+	// Started is the time the crawl started. Elapsed is its duration so far, or in total
+	// once it has finished.
+	Started time.Time
+	Elapsed time.Duration
+
+	// Done is set once the crawl has finished.
+	Done bool
+
+	// URLs is the number of URLs that were completed, successfully or not, excluding retries.
+	URLs int64
+
+	// Succeeded and Failed split URLs into those that were handled without error and
+	// those with at least one error. ErrorKinds counts the errors by kind, so a URL
+	// with a handler error and a body error counts twice there.
+	Succeeded  int64
+	Failed     int64
+	ErrorKinds map[ErrorKind]int64
+
+	// Retries is the number of attempts that were retried.
+	Retries int64
+
+	// StatusCodes counts the responses passed to the handlers by status code.
+	StatusCodes map[int]int64
+
+	// BytesRead is the number of body bytes read by the handlers.
+	BytesRead int64
+
+	// TTFB and Total are percentiles of the time to first byte and of the total time,
+	// until the body was read or closed, of the responses. See Timings.
+	TTFB  Percentiles
+	Total Percentiles
+}
+
+// Percentiles summarizes a distribution of durations. Percentiles are accurate to within 10%.
+type Percentiles struct {
+	P50, P90, P99, Max time.Duration
+}
+
+// RunWithStats is Run, returning a summary of the crawl.
+func (c *Crawler) RunWithStats(ctx context.Context, urlGen URLGenerator) (Stats, error) {
+	s := newRunStats()
+	err := c.crawl(ctx, URLItems(urlGen), c.handler, func(res *Result, err error) {
+		c.config.ErrorHandler(res.URL, err)
+	}, s)
+	return s.snapshot(), err
+}
+
+// Stats returns a snapshot of the statistics of the crawl in progress, or of the last one.
+// It is safe to call while crawling, e.g. to report progress.
+func (c *Crawler) Stats() Stats {
+	s := c.stats.Load()
+	if s == nil {
+		return Stats{}
+	}
+	return s.snapshot()
+}
+
+// runStats collects the statistics of a crawl.
+type runStats struct {
+	bytesRead atomic.Int64 // updated by countingBody
+
+	mu          sync.Mutex
+	started     time.Time
+	finished    time.Time
+	urls        int64
+	succeeded   int64
+	failed      int64
+	retries     int64
+	errorKinds  map[ErrorKind]int64
+	statusCodes map[int]int64
+	ttfb        latencyHistogram
+	total       latencyHistogram
+}
+
+func newRunStats() *runStats {
+	return &runStats{
+		started:     time.Now(),
+		errorKinds:  make(map[ErrorKind]int64),
+		statusCodes: make(map[int]int64),
+	}
+}
+
+// response records a response that is passed to the handlers.
+func (s *runStats) response(res *Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statusCodes[res.Response.StatusCode]++
+}
+
+// failure records an error.
+func (s *runStats) failure(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errorKinds[KindOf(err)]++
+}
+
+// completed records a URL that was completed, or requeued for a retry, with the Result
+// of the attempt. Its body has been closed, so the timings are final.
+func (s *runStats) completed(res *Result, requeued bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if requeued {
+		s.retries++
+		return
+	}
+	s.urls++
+	if res.failed {
+		s.failed++
+	} else {
+		s.succeeded++
+	}
+	if res.Response != nil {
+		if res.Timings.TTFB > 0 {
+			s.ttfb.add(res.Timings.TTFB)
+		}
+		if res.Timings.Total > 0 {
+			s.total.add(res.Timings.Total)
+		}
+	}
+}
+
+// finish records the end of the crawl.
+func (s *runStats) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finished = time.Now()
+}
+
+func (s *runStats) snapshot() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := Stats{
+		Started:     s.started,
+		Done:        !s.finished.IsZero(),
+		URLs:        s.urls,
+		Succeeded:   s.succeeded,
+		Failed:      s.failed,
+		ErrorKinds:  maps.Clone(s.errorKinds),
+		Retries:     s.retries,
+		StatusCodes: maps.Clone(s.statusCodes),
+		BytesRead:   s.bytesRead.Load(),
+		TTFB:        s.ttfb.percentiles(),
+		Total:       s.total.percentiles(),
+	}
+	if stats.Done {
+		stats.Elapsed = s.finished.Sub(s.started)
+	} else {
+		stats.Elapsed = time.Since(s.started)
+	}
+	return stats
+}
+
+const (
+	latencyBase   = 100 * time.Microsecond
+	latencyGrowth = 1.1
+)
+
+// latencyHistogram counts durations in exponential buckets, so percentiles take
+// constant memory however long the crawl. Bucket i holds durations up to
+// latencyBase * latencyGrowth^i.
+type latencyHistogram struct {
+	counts []int64
+	total  int64
+	max    time.Duration
+}
+
+func (h *latencyHistogram) add(d time.Duration) {
+	i := 0
+	if d > latencyBase {
+		i = int(math.Ceil(math.Log(float64(d)/float64(latencyBase)) / math.Log(latencyGrowth)))
+	}
+	for len(h.counts) <= i {
+		h.counts = append(h.counts, 0)
+	}
+	h.counts[i]++
+	h.total++
+	h.max = max(h.max, d)
+}
+
+func (h *latencyHistogram) percentiles() Percentiles {
+	return Percentiles{
+		P50: h.percentile(0.5),
+		P90: h.percentile(0.9),
+		P99: h.percentile(0.99),
+		Max: h.max,
+	}
+}
+
+// percentile returns the upper bound of the bucket holding the p-th percentile, or zero.
+func (h *latencyHistogram) percentile(p float64) time.Duration {
+	rank := int64(math.Ceil(p * float64(h.total)))
+	var seen int64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank && seen > 0 {
+			bound := time.Duration(float64(latencyBase) * math.Pow(latencyGrowth, float64(i)))
+			return min(bound, h.max)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,146 @@
+package crawl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunWithStats(t *testing.T) {
+	ctx := context.Background()
+
+	var flaky atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+			return
+		case "/flaky":
+			if flaky.Add(1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+		io.WriteString(w, "hello") //nolint:errcheck
+	}))
+	defer server.Close()
+
+	crawler := New(ctx, Config{
+		WorkerCount: 2,
+		UserAgent:   "test",
+		RetryPolicy: BackoffRetryPolicy(2, time.Millisecond, time.Millisecond),
+		// The rejected URL also fails to close its body, so it has two errors
+		Middleware: []Middleware{func(next FetchFunc) FetchFunc {
+			return func(f *Fetch) (*http.Response, error) {
+				resp, err := next(f)
+				if err == nil && strings.HasSuffix(f.URL, "/reject") {
+					resp.Body = closeErrorBody{resp.Body}
+				}
+				return resp, err
+			}
+		}},
+		ResultHandler: func(res *Result) error {
+			if strings.HasSuffix(res.URL, "/reject") {
+				return errors.New("rejected")
+			}
+			_, err := io.Copy(io.Discard, res.Response.Body)
+			return err
+		},
+	})
+	if stats := crawler.Stats(); stats.URLs != 0 || !stats.Started.IsZero() {
+		t.Errorf("expected empty stats before a run, got %+v", stats)
+	}
+
+	// Snapshots are taken while crawling
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for !crawler.Stats().Done {
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	urls := func(yield func(string) bool) {
+		for _, u := range []string{server.URL + "/ok", server.URL + "/missing", server.URL + "/flaky", server.URL + "/reject", "://invalid"} {
+			if !yield(u) {
+				return
+			}
+		}
+	}
+	stats, err := crawler.RunWithStats(ctx, urls)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	<-done
+
+	if stats.URLs != 5 || stats.Retries != 1 {
+		t.Errorf("expected 5 URLs and 1 retry, got %d and %d", stats.URLs, stats.Retries)
+	}
+	if stats.Succeeded != 3 || stats.Failed != 2 {
+		t.Errorf("expected 3 succeeded and 2 failed, got %d and %d", stats.Succeeded, stats.Failed)
+	}
+	if stats.ErrorKinds[KindHandler] != 1 || stats.ErrorKinds[KindBodyRead] != 1 || stats.ErrorKinds[KindRequestBuild] != 1 {
+		t.Errorf("unexpected error kinds: %v", stats.ErrorKinds)
+	}
+	if stats.StatusCodes[http.StatusOK] != 3 || stats.StatusCodes[http.StatusNotFound] != 1 {
+		t.Errorf("unexpected status codes: %v", stats.StatusCodes)
+	}
+	if stats.BytesRead != int64(len("hello")*2+len("404 page not found\n")) {
+		t.Errorf("unexpected bytes read: %d", stats.BytesRead)
+	}
+	if stats.TTFB.P50 <= 0 || stats.TTFB.P50 > stats.TTFB.P99 || stats.TTFB.P99 > stats.TTFB.Max {
+		t.Errorf("unexpected TTFB percentiles: %+v", stats.TTFB)
+	}
+	if stats.Total.P50 < stats.TTFB.P50 || stats.Total.P50 > stats.Total.P99 || stats.Total.P99 > stats.Total.Max {
+		t.Errorf("unexpected total percentiles: %+v", stats.Total)
+	}
+	if !stats.Done || stats.Elapsed <= 0 {
+		t.Errorf("expected a finished run, got %+v", stats)
+	}
+
+	// The last run stays available
+	if last := crawler.Stats(); last.URLs != stats.URLs || last.Elapsed != stats.Elapsed {
+		t.Errorf("expected the stats of the last run, got %+v", last)
+	}
+}
+
+// closeErrorBody is a response body that fails to close.
+type closeErrorBody struct {
+	io.ReadCloser
+}
+
+func (b closeErrorBody) Close() error {
+	b.ReadCloser.Close() //nolint:errcheck
+	return errors.New("close failed")
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	var h latencyHistogram
+	if p := h.percentile(0.5); p != 0 {
+		t.Errorf("expected 0 for an empty histogram, got %v", p)
+	}
+	for i := 1; i <= 100; i++ {
+		h.add(time.Duration(i) * time.Millisecond)
+	}
+	tests := []struct {
+		p        float64
+		expected time.Duration
+	}{
+		{0.5, 50 * time.Millisecond},
+		{0.9, 90 * time.Millisecond},
+		{0.99, 99 * time.Millisecond},
+		{1, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		got := h.percentile(tt.p)
+		if got < tt.expected || float64(got) > float64(tt.expected)*latencyGrowth {
+			t.Errorf("p%v: expected about %v, got %v", tt.p*100, tt.expected, got)
+		}
+	}
+}
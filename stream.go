@@ -0,0 +1,67 @@
+package crawl
+
+import (
+	"context"
+	"iter"
+)
+
+// streamItem is a result or error passed from a worker to the consumer of Results.
+type streamItem struct {
+	res  *Result
+	err  error
+	done chan struct{} // closed when the consumer is done with the item
+}
+
+// Results crawls URLs from the generator and yields each result, or the error
+// that prevented one, as an iterator. Workers block until the consumer has
+// handled their result, so a slow consumer throttles the crawl. The response
+// body of a result is readable until the next iteration.
+//
+// Breaking out of the loop stops the crawl and waits for the workers to exit.
+// The ResponseHandler, ResultHandler and ErrorHandler from the Config are not used.
+// If ctx is cancelled, the last pair yielded is (nil, ctx.Err()).
+func (c *Crawler) Results(ctx context.Context, urlGen URLGenerator) iter.Seq2[*Result, error] {
+	return c.ItemResults(ctx, URLItems(urlGen))
+}
+
+// ItemResults is Results for items with metadata, which is passed on as Result.Meta.
+func (c *Crawler) ItemResults(ctx context.Context, items ItemGenerator) iter.Seq2[*Result, error] {
+	return func(yield func(*Result, error) bool) {
+		runCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		stream := make(chan streamItem)
+		send := func(res *Result, err error) {
+			item := streamItem{res: res, err: err, done: make(chan struct{})}
+			select {
+			case stream <- item:
+				<-item.done
+			case <-runCtx.Done():
+			}
+		}
+
+		go func() {
+			defer close(stream)
+			_ = c.crawl(runCtx, items, func(res *Result) error {
+				send(res, nil)
+				return nil
+			}, send, newRunStats())
+		}()
+
+		for item := range stream {
+			more := yield(item.res, item.err)
+			close(item.done)
+			if !more {
+				cancel()
+				for item := range stream {
+					close(item.done)
+				}
+				return
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
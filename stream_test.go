@@ -0,0 +1,116 @@
+package crawl
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestResults(t *testing.T) {
+	ctx := context.Background()
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte("body")) //nolint:errcheck
+	}))
+	defer server.Close()
+
+	crawler := New(ctx, Config{WorkerCount: 2, UserAgent: "test"})
+
+	t.Run("yields results and errors", func(t *testing.T) {
+		urls := func(yield func(string) bool) {
+			for _, u := range []string{server.URL, "://invalid", server.URL} {
+				if !yield(u) {
+					return
+				}
+			}
+		}
+
+		var results, errs int
+		for res, err := range crawler.Results(ctx, urls) {
+			if err != nil {
+				if res == nil || res.URL != "://invalid" {
+					t.Errorf("expected error result for invalid URL, got %+v", res)
+				}
+				errs++
+				continue
+			}
+			body, err := io.ReadAll(res.Response.Body)
+			if err != nil || string(body) != "body" {
+				t.Errorf("expected readable body, got %q, %v", body, err)
+			}
+			results++
+		}
+
+		if results != 2 || errs != 1 {
+			t.Errorf("expected 2 results and 1 error, got %d and %d", results, errs)
+		}
+	})
+
+	t.Run("stops when consumer breaks", func(t *testing.T) {
+		requests.Store(0)
+		urls := func(yield func(string) bool) {
+			for i := 0; i < 1000; i++ {
+				if !yield(server.URL) {
+					return
+				}
+			}
+		}
+
+		for range crawler.Results(ctx, urls) {
+			break
+		}
+
+		if n := requests.Load(); n > 10 {
+			t.Errorf("expected crawl to stop after break, got %d requests", n)
+		}
+	})
+
+	t.Run("reports cancellation", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		urls := func(yield func(string) bool) {
+			for i := 0; i < 1000; i++ {
+				if !yield(server.URL) {
+					return
+				}
+			}
+		}
+
+		var lastErr error
+		for _, err := range crawler.Results(ctx, urls) {
+			cancel()
+			lastErr = err
+		}
+		if lastErr != context.Canceled {
+			t.Errorf("expected context.Canceled, got %v", lastErr)
+		}
+	})
+}
+
+func TestItemResults(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	crawler := New(ctx, Config{WorkerCount: 1, UserAgent: "test"})
+	items := func(yield func(Item) bool) {
+		_ = yield(Item{URL: server.URL, Meta: "a"}) && yield(Item{URL: "://invalid", Meta: "b"})
+	}
+
+	metas := map[any]bool{}
+	for res, err := range crawler.ItemResults(ctx, items) {
+		if res.Response != nil {
+			res.Response.Body.Close()
+		}
+		metas[res.Meta] = err != nil
+	}
+	if aFailed, bFailed := metas["a"], metas["b"]; len(metas) != 2 || aFailed || !bFailed {
+		t.Errorf("expected a result for a and an error for b, got %v", metas)
+	}
+}
@@ -0,0 +1,179 @@
+package crawl
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timeouts limits the phases of a single request. They apply per URL and attempt, so an
+// expired timeout fails that request without cancelling the run. Zero means no limit.
+type Timeouts struct {
+	// Dial limits the DNS lookup and TCP connect.
+	Dial time.Duration
+
+	// TLSHandshake limits the TLS handshake.
+	TLSHandshake time.Duration
+
+	// ResponseHeader limits the time from writing the request until the response starts.
+	ResponseHeader time.Duration
+
+	// BodyRead limits the time from receiving the response until its body is fully read or closed.
+	BodyRead time.Duration
+
+	// Total limits the whole request, including redirects and reading the body.
+	Total time.Duration
+}
+
+// Request phases reported in a TimeoutError.
+const (
+	PhaseDial           = "dial"
+	PhaseTLSHandshake   = "tls handshake"
+	PhaseResponseHeader = "response header"
+	PhaseBodyRead       = "body read"
+	PhaseTotal          = "total"
+)
+
+// TimeoutError is a request phase that did not complete within its timeout.
+// It is wrapped in an *Error of KindTimeout.
+type TimeoutError struct {
+	// Phase is the phase that expired, e.g. PhaseResponseHeader.
+	Phase string
+
+	// Timeout is the configured timeout of the phase.
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timeout after %v", e.Phase, e.Timeout)
+}
+
+// deadlines cancels a request context when a phase exceeds its timeout.
+// Phase timers are started and stopped by httptrace hooks.
+type deadlines struct {
+	timeouts Timeouts
+	cancel   context.CancelCauseFunc
+
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+// withDeadlines returns a context for a single request that is cancelled when
+// a phase times out, with a *TimeoutError as cause.
+func withDeadlines(ctx context.Context, timeouts Timeouts) (context.Context, *deadlines) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	d := &deadlines{timeouts: timeouts, cancel: cancel, timers: make(map[string]*time.Timer)}
+	d.start(PhaseTotal, timeouts.Total)
+	return httptrace.WithClientTrace(ctx, d.trace()), d
+}
+
+// start starts the timer of a phase, unless it is running or the timeout is zero.
+func (d *deadlines) start(phase string, timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.timers[phase]; ok || d.timers == nil {
+		return
+	}
+	d.timers[phase] = time.AfterFunc(timeout, func() {
+		d.cancel(&TimeoutError{Phase: phase, Timeout: timeout})
+	})
+}
+
+// stop stops the timer of a phase.
+func (d *deadlines) stop(phase string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if timer, ok := d.timers[phase]; ok {
+		timer.Stop()
+		delete(d.timers, phase)
+	}
+}
+
+// close stops all timers and releases the context.
+func (d *deadlines) close() {
+	d.mu.Lock()
+	for _, timer := range d.timers {
+		timer.Stop()
+	}
+	d.timers = nil
+	d.mu.Unlock()
+	d.cancel(nil)
+}
+
+func (d *deadlines) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			d.start(PhaseDial, d.timeouts.Dial)
+		},
+		ConnectStart: func(_, _ string) {
+			d.start(PhaseDial, d.timeouts.Dial)
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				d.stop(PhaseDial)
+			}
+		},
+		TLSHandshakeStart: func() {
+			d.start(PhaseTLSHandshake, d.timeouts.TLSHandshake)
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			d.stop(PhaseTLSHandshake)
+		},
+		GotConn: func(httptrace.GotConnInfo) {
+			d.stop(PhaseDial)
+			d.stop(PhaseTLSHandshake)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			d.start(PhaseResponseHeader, d.timeouts.ResponseHeader)
+		},
+		GotFirstResponseByte: func() {
+			d.stop(PhaseResponseHeader)
+		},
+	}
+}
+
+// timeoutCause returns the *TimeoutError that cancelled ctx, or nil.
+func timeoutCause(ctx context.Context) *TimeoutError {
+	var timeoutErr *TimeoutError
+	if errors.As(context.Cause(ctx), &timeoutErr) {
+		return timeoutErr
+	}
+	return nil
+}
+
+// deadlineBody enforces the body read timeout, and reports read errors caused
+// by an expired timeout as an *Error of KindTimeout.
+type deadlineBody struct {
+	io.ReadCloser
+	ctx context.Context
+	d   *deadlines
+	url string
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.d.stop(PhaseBodyRead)
+	} else if err != nil {
+		if cause := timeoutCause(b.ctx); cause != nil {
+			err = &Error{Kind: KindTimeout, URL: b.url, Err: cause}
+		}
+	}
+	return n, err
+}
+
+func (b *deadlineBody) Close() error {
+	b.d.stop(PhaseBodyRead)
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,93 @@
+package crawl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTimeouts(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow-header":
+			time.Sleep(200 * time.Millisecond)
+		case "/slow-body":
+			io.WriteString(w, "partial") //nolint:errcheck
+			w.(http.Flusher).Flush()
+			time.Sleep(200 * time.Millisecond)
+		}
+		io.WriteString(w, "done") //nolint:errcheck
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		path     string
+		timeouts Timeouts
+		phase    string
+	}{
+		{"response header", "/slow-header", Timeouts{ResponseHeader: 50 * time.Millisecond}, PhaseResponseHeader},
+		{"body read", "/slow-body", Timeouts{BodyRead: 50 * time.Millisecond}, PhaseBodyRead},
+		{"total", "/slow-body", Timeouts{Total: 50 * time.Millisecond}, PhaseTotal},
+		{"within limits", "/fast", Timeouts{ResponseHeader: time.Second, BodyRead: time.Second, Total: time.Second}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var errs []error
+			var bodies []string
+			crawler := New(ctx, Config{
+				WorkerCount: 1,
+				UserAgent:   "test",
+				Timeouts:    tt.timeouts,
+				ResultHandler: func(res *Result) error {
+					body, err := io.ReadAll(res.Response.Body)
+					mu.Lock()
+					bodies = append(bodies, string(body))
+					mu.Unlock()
+					return err
+				},
+				ErrorHandler: func(url string, err error) {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				},
+			})
+
+			// The run continues after a timeout
+			urls := func(yield func(string) bool) {
+				_ = yield(server.URL+tt.path) && yield(server.URL+"/fast")
+			}
+			if err := crawler.Run(ctx, urls); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if tt.phase == "" {
+				if len(errs) != 0 {
+					t.Fatalf("expected no errors, got %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 {
+				t.Fatalf("expected 1 error, got %v", errs)
+			}
+			if !IsTimeout(errs[0]) {
+				t.Errorf("expected a timeout error, got %s: %v", KindOf(errs[0]), errs[0])
+			}
+			var timeoutErr *TimeoutError
+			if !errors.As(errs[0], &timeoutErr) || timeoutErr.Phase != tt.phase {
+				t.Errorf("expected %s timeout, got %v", tt.phase, errs[0])
+			}
+			if bodies[len(bodies)-1] != "done" {
+				t.Errorf("expected the next URL to be fetched, got %q", bodies)
+			}
+		})
+	}
+}
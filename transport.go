@@ -0,0 +1,376 @@
+package crawl
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+	"golang.org/x/net/proxy"
+)
+
+// orderedTransport is an http.RoundTripper that writes request headers in the order
+// of the HeaderProfile attached to the request context, instead of the map order of
+// net/http. On HTTPS it negotiates HTTP/2 if the profile has an HTTP2Profile, and
+// opens the connection with the browser's SETTINGS and pseudo-header order.
+// Every request uses a new connection.
+type orderedTransport struct {
+	dialer    dialer
+	tlsConfig *tls.Config
+
+	// proxy returns the proxy for a request, or nil for a direct connection.
+	// HTTP and HTTPS proxies are used with CONNECT, also for plain HTTP.
+	proxy func(*http.Request) (*url.URL, error)
+}
+
+// dialer opens connections, like *net.Dialer and *Resolver.
+type dialer interface {
+	Dial(network, addr string) (net.Conn, error)
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+func newOrderedTransport(tlsConfig *tls.Config) *orderedTransport {
+	return &orderedTransport{
+		dialer:    &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		tlsConfig: tlsConfig,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *orderedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace == nil {
+		trace = &httptrace.ClientTrace{}
+	}
+
+	profile := profileFromContext(ctx)
+	if profile == nil {
+		profile = defaultProfile(defaultUserAgent)
+	}
+
+	header := req.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	if header.Get("Connection") == "" && profile.Get("Connection") != "" {
+		header.Set("Connection", profile.Get("Connection"))
+	}
+
+	fields := orderedHeaders(header, profile)
+	if err := validateRequest(req, fields); err != nil {
+		return nil, err
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close() //nolint:errcheck
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+	}
+
+	conn, state, err := t.dial(ctx, req, profile.HTTP2 != nil, trace)
+	if err != nil {
+		return nil, err
+	}
+	// Unblock reads and writes when the request is cancelled
+	stop := context.AfterFunc(ctx, func() { conn.Close() }) //nolint:errcheck
+	release := func() error {
+		stop()
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			return err
+		}
+		return nil
+	}
+
+	var resp *http.Response
+	if state != nil && state.NegotiatedProtocol == "h2" {
+		resp, err = roundTripHTTP2(conn, req, fields, body, profile.HTTP2, trace, release)
+	} else {
+		resp, err = roundTripHTTP1(conn, req, fields, body, trace, release)
+	}
+	if err != nil {
+		release() //nolint:errcheck
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	resp.TLS = state
+	return resp, nil
+}
+
+// validateRequest rejects a method, Host or header fields that would break the request
+// framing on the wire, such as a value with a CRLF, like net/http does.
+func validateRequest(req *http.Request, fields []HeaderField) error {
+	if !httpguts.ValidHeaderFieldName(req.Method) {
+		return fmt.Errorf("invalid method %q", req.Method)
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	if !httpguts.ValidHostHeader(host) {
+		return fmt.Errorf("invalid Host header %q", host)
+	}
+	for _, f := range fields {
+		if !httpguts.ValidHeaderFieldName(f.Name) {
+			return fmt.Errorf("invalid header field name %q", f.Name)
+		}
+		if !httpguts.ValidHeaderFieldValue(f.Value) {
+			return fmt.Errorf("invalid header field value for %q", f.Name)
+		}
+	}
+	return nil
+}
+
+// dial connects to the host of req, with a TLS handshake for https.
+func (t *orderedTransport) dial(ctx context.Context, req *http.Request, h2 bool, trace *httptrace.ClientTrace) (net.Conn, *tls.ConnectionState, error) {
+	u := req.URL
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+
+	if trace.GetConn != nil {
+		trace.GetConn(addr)
+	}
+	conn, err := t.dialTCP(ctx, req, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var state *tls.ConnectionState
+	if u.Scheme == "https" {
+		cfg := &tls.Config{}
+		if t.tlsConfig != nil {
+			cfg = t.tlsConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		cfg.NextProtos = []string{"http/1.1"}
+		if h2 {
+			cfg.NextProtos = []string{"h2", "http/1.1"}
+		}
+
+		tlsConn := tls.Client(conn, cfg)
+		if trace.TLSHandshakeStart != nil {
+			trace.TLSHandshakeStart()
+		}
+		err := tlsConn.HandshakeContext(ctx)
+		cs := tlsConn.ConnectionState()
+		if trace.TLSHandshakeDone != nil {
+			trace.TLSHandshakeDone(cs, err)
+		}
+		if err != nil {
+			conn.Close() //nolint:errcheck
+			return nil, nil, err
+		}
+		conn, state = tlsConn, &cs
+	}
+
+	if trace.GotConn != nil {
+		trace.GotConn(httptrace.GotConnInfo{Conn: conn})
+	}
+	return conn, state, nil
+}
+
+// dialTCP connects to addr, through the proxy for req if any.
+// The dialer reports DNS and connect phases to the trace in ctx.
+func (t *orderedTransport) dialTCP(ctx context.Context, req *http.Request, addr string) (net.Conn, error) {
+	var proxyURL *url.URL
+	if t.proxy != nil {
+		var err error
+		if proxyURL, err = t.proxy(req); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case proxyURL == nil:
+		return t.dialer.DialContext(ctx, "tcp", addr)
+	case proxyURL.Scheme == "socks5" || proxyURL.Scheme == "socks5h":
+		dialer, err := proxy.FromURL(proxyURL, t.dialer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to use proxy %s: %w", proxyURL.Redacted(), err)
+		}
+		return dialer.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+	default:
+		conn, err := t.dialConnect(ctx, proxyURL, addr)
+		if err != nil {
+			return nil, &proxyError{err}
+		}
+		return conn, nil
+	}
+}
+
+// dialConnect opens a tunnel to addr through an HTTP or HTTPS proxy with CONNECT.
+func (t *orderedTransport) dialConnect(ctx context.Context, proxyURL *url.URL, addr string) (net.Conn, error) {
+	port := proxyURL.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[proxyURL.Scheme]
+	}
+	conn, err := t.dialer.DialContext(ctx, "tcp", net.JoinHostPort(proxyURL.Hostname(), port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy %s: %w", proxyURL.Redacted(), err)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() }) //nolint:errcheck
+	defer stop()
+
+	if proxyURL.Scheme == "https" {
+		cfg := &tls.Config{}
+		if t.tlsConfig != nil {
+			cfg = t.tlsConfig.Clone()
+		}
+		cfg.ServerName = proxyURL.Hostname()
+		cfg.NextProtos = nil
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close() //nolint:errcheck
+			return nil, fmt.Errorf("failed to connect to proxy %s: %w", proxyURL.Redacted(), err)
+		}
+		conn = tlsConn
+	}
+
+	connect := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+	if u := proxyURL.User; u != nil {
+		password, _ := u.Password()
+		connect += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+password)) + "\r\n"
+	}
+	if _, err := io.WriteString(conn, connect+"\r\n"); err != nil {
+		conn.Close() //nolint:errcheck
+		return nil, fmt.Errorf("failed to connect to proxy %s: %w", proxyURL.Redacted(), err)
+	}
+
+	// The client speaks first through the tunnel, so nothing is buffered beyond the response
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		conn.Close() //nolint:errcheck
+		return nil, fmt.Errorf("failed to connect to proxy %s: %w", proxyURL.Redacted(), err)
+	}
+	// The body is not closed, as that would read from the tunnel
+	if resp.StatusCode != http.StatusOK {
+		conn.Close() //nolint:errcheck
+		return nil, fmt.Errorf("proxy %s refused CONNECT to %s: %s", proxyURL.Redacted(), addr, resp.Status)
+	}
+	return conn, nil
+}
+
+// orderedHeaders returns the headers in the order of the profile,
+// followed by headers the profile does not know in sorted order.
+func orderedHeaders(header http.Header, profile *HeaderProfile) []HeaderField {
+	var fields []HeaderField
+	seen := make(map[string]bool)
+	add := func(name string) {
+		key := http.CanonicalHeaderKey(name)
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		for _, v := range header[key] {
+			fields = append(fields, HeaderField{name, v})
+		}
+	}
+	for _, f := range profile.Headers {
+		add(f.Name)
+	}
+	for _, name := range slices.Sorted(maps.Keys(header)) {
+		add(name)
+	}
+	return fields
+}
+
+// roundTripHTTP1 writes req as HTTP/1.1 with headers in the given order and reads the response.
+// release is called when the response body is closed.
+func roundTripHTTP1(conn net.Conn, req *http.Request, fields []HeaderField, body []byte, trace *httptrace.ClientTrace, release func() error) (*http.Response, error) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "%s %s HTTP/1.1\r\nHost: %s\r\n", req.Method, req.URL.RequestURI(), host)
+	for _, f := range fields {
+		switch http.CanonicalHeaderKey(f.Name) {
+		case "Host", "Content-Length", "Transfer-Encoding":
+			continue
+		}
+		fmt.Fprintf(w, "%s: %s\r\n", f.Name, f.Value)
+	}
+	if len(body) > 0 || req.Method == http.MethodPost || req.Method == http.MethodPut {
+		fmt.Fprintf(w, "Content-Length: %d\r\n", len(body))
+	}
+	w.WriteString("\r\n") //nolint:errcheck
+	if trace.WroteHeaders != nil {
+		trace.WroteHeaders()
+	}
+	w.Write(body) //nolint:errcheck
+	err := w.Flush()
+	if trace.WroteRequest != nil {
+		trace.WroteRequest(httptrace.WroteRequestInfo{Err: err})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write request: %w", err)
+	}
+
+	r := bufio.NewReader(conn)
+	if _, err := r.Peek(1); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if trace.GotFirstResponseByte != nil {
+		trace.GotFirstResponseByte()
+	}
+	for {
+		resp, err := http.ReadResponse(r, req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response: %w", err)
+		}
+		// Skip interim responses such as 103 Early Hints
+		if resp.StatusCode >= 100 && resp.StatusCode < 200 && resp.StatusCode != http.StatusSwitchingProtocols {
+			continue
+		}
+		resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+		return resp, nil
+	}
+}
+
+// releaseBody calls release when the body is closed, to close the connection.
+type releaseBody struct {
+	io.ReadCloser
+	release func() error
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	if rerr := b.release(); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// contentLength parses a Content-Length value, or returns -1.
+func contentLength(value string) int64 {
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
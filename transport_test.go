@@ -0,0 +1,266 @@
+package crawl
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// serveOnce accepts a single connection on a new listener and passes it to handle.
+func serveOnce(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(conn)
+	}()
+	return ln.Addr().String()
+}
+
+// crawlOne crawls a single URL with ordered headers and returns its result and body.
+func crawlOne(t *testing.T, url string, profile *HeaderProfile) (*Result, string) {
+	t.Helper()
+	ctx := context.Background()
+
+	var result *Result
+	var body string
+	crawler := New(ctx, Config{
+		HeaderProfiles: []*HeaderProfile{profile},
+		OrderedHeaders: true,
+		ResultHandler: func(res *Result) error {
+			data, err := io.ReadAll(res.Response.Body)
+			result, body = res, string(data)
+			return err
+		},
+		ErrorHandler: func(url string, err error) { t.Errorf("unexpected error for %s: %v", url, err) },
+	})
+	if err := crawler.Run(ctx, func(yield func(string) bool) { yield(url) }); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if result == nil {
+		t.Fatal("expected a result")
+	}
+	return result, body
+}
+
+func TestOrderedHeadersHTTP1(t *testing.T) {
+	raw := make(chan string, 1)
+	addr := serveOnce(t, func(conn net.Conn) {
+		var req bytes.Buffer
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			req.WriteString(line)
+			if err != nil || line == "\r\n" {
+				break
+			}
+		}
+		raw <- req.String()
+
+		var body bytes.Buffer
+		gz := gzip.NewWriter(&body)
+		gz.Write([]byte("<html>hi</html>"))                                                              //nolint:errcheck
+		gz.Close()                                                                                       //nolint:errcheck
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Encoding: gzip\r\n"+ //nolint:errcheck
+			"Content-Length: "+strconv.Itoa(body.Len())+"\r\n\r\n"+body.String())
+	})
+
+	profile := ChromeWindowsProfile("150")
+	res, body := crawlOne(t, "http://"+addr+"/page?q=1", profile)
+
+	if body != "<html>hi</html>" {
+		t.Errorf("expected decoded body, got %q", body)
+	}
+	if res.Protocol != "HTTP/1.1" {
+		t.Errorf("expected HTTP/1.1, got %q", res.Protocol)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(<-raw, "\r\n\r\n"), "\r\n")
+	if lines[0] != "GET /page?q=1 HTTP/1.1" || lines[1] != "Host: "+addr {
+		t.Errorf("unexpected request line and Host: %q", lines[:2])
+	}
+
+	var names []string
+	for _, line := range lines[2:] {
+		name, value, _ := strings.Cut(line, ": ")
+		names = append(names, name)
+		if name == "Accept-Encoding" && value != profile.Get("Accept-Encoding") {
+			t.Errorf("expected the encodings of the profile, got %q", value)
+		}
+	}
+	var expected []string
+	for _, f := range profile.Headers {
+		expected = append(expected, f.Name)
+	}
+	if !slices.Equal(names, expected) {
+		t.Errorf("expected header order\n%v\ngot\n%v", expected, names)
+	}
+}
+
+func TestOrderedHeadersHTTP2(t *testing.T) {
+	// Borrow the test certificate of httptest
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	cert := ts.TLS.Certificates[0]
+	ts.Close()
+
+	type request struct {
+		settings     []http2.Setting
+		windowUpdate uint32
+		headers      []string
+		weight       uint8
+	}
+	got := make(chan request, 1)
+
+	addr := serveOnce(t, func(conn net.Conn) {
+		tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"h2"}})
+		preface := make([]byte, len(http2.ClientPreface))
+		if _, err := io.ReadFull(tlsConn, preface); err != nil {
+			return
+		}
+
+		fr := http2.NewFramer(tlsConn, tlsConn)
+		fr.ReadMetaHeaders = hpack.NewDecoder(65536, nil)
+		var req request
+		for {
+			f, err := fr.ReadFrame()
+			if err != nil {
+				return
+			}
+			switch f := f.(type) {
+			case *http2.SettingsFrame:
+				f.ForeachSetting(func(s http2.Setting) error {
+					req.settings = append(req.settings, s)
+					return nil
+				})
+			case *http2.WindowUpdateFrame:
+				req.windowUpdate = f.Increment
+			case *http2.MetaHeadersFrame:
+				for _, hf := range f.Fields {
+					req.headers = append(req.headers, hf.Name)
+				}
+				req.weight = f.Priority.Weight
+				got <- req
+
+				var block bytes.Buffer
+				enc := hpack.NewEncoder(&block)
+				enc.WriteField(hpack.HeaderField{Name: ":status", Value: "200"})                                      //nolint:errcheck
+				enc.WriteField(hpack.HeaderField{Name: "content-type", Value: "text/html"})                           //nolint:errcheck
+				fr.WriteSettings()                                                                                    //nolint:errcheck
+				fr.WriteSettingsAck()                                                                                 //nolint:errcheck
+				fr.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: block.Bytes(), EndHeaders: true}) //nolint:errcheck
+				fr.WriteData(1, false, []byte("<html>"))                                                              //nolint:errcheck
+				fr.WriteData(1, true, []byte("hi</html>"))                                                            //nolint:errcheck
+				io.Copy(io.Discard, tlsConn)                                                                          //nolint:errcheck
+				return
+			}
+		}
+	})
+
+	profile := ChromeMacOSProfile("150")
+	res, body := crawlOne(t, "https://"+addr+"/", profile)
+
+	if body != "<html>hi</html>" {
+		t.Errorf("expected body, got %q", body)
+	}
+	if res.Protocol != "HTTP/2.0" || res.Response.StatusCode != http.StatusOK {
+		t.Errorf("expected HTTP/2.0 200, got %q %d", res.Protocol, res.Response.StatusCode)
+	}
+
+	req := <-got
+	var settings []HTTP2Setting
+	for _, s := range req.settings {
+		settings = append(settings, HTTP2Setting{uint16(s.ID), s.Val})
+	}
+	if !slices.Equal(settings, profile.HTTP2.Settings) {
+		t.Errorf("expected settings %v, got %v", profile.HTTP2.Settings, settings)
+	}
+	if req.windowUpdate != profile.HTTP2.WindowUpdate {
+		t.Errorf("expected window update %d, got %d", profile.HTTP2.WindowUpdate, req.windowUpdate)
+	}
+	if req.weight != 255 {
+		t.Errorf("expected weight 256, got %d", int(req.weight)+1)
+	}
+
+	expected := slices.Clone(profile.HTTP2.PseudoHeaderOrder)
+	for _, f := range profile.Headers {
+		if f.Name != "Connection" {
+			expected = append(expected, strings.ToLower(f.Name))
+		}
+	}
+	if !slices.Equal(req.headers, expected) {
+		t.Errorf("expected header order\n%v\ngot\n%v", expected, req.headers)
+	}
+}
+
+func TestOrderedTransportInvalidHeaders(t *testing.T) {
+	ctx := context.Background()
+
+	var requests atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	})
+	h1 := httptest.NewServer(handler)
+	defer h1.Close()
+	h2 := httptest.NewUnstartedServer(handler)
+	h2.EnableHTTP2 = true
+	h2.StartTLS()
+	defer h2.Close()
+
+	tests := map[string]func(req *http.Request){
+		"value":  func(req *http.Request) { req.Header.Set("X-Id", "a\r\nX-Injected: yes") },
+		"name":   func(req *http.Request) { req.Header["X-Bad Name"] = []string{"a"} },
+		"host":   func(req *http.Request) { req.Host = "example.com\r\nX-Injected: yes" },
+		"method": func(req *http.Request) { req.Method = "GET /x HTTP/1.1\r\n" },
+	}
+	for _, server := range []*httptest.Server{h1, h2} {
+		for name, modify := range tests {
+			var failure error
+			crawler := New(ctx, Config{
+				HeaderProfiles: []*HeaderProfile{ChromeWindowsProfile("150")},
+				OrderedHeaders: true,
+				RequestBuilder: func(ctx context.Context, url string) (*http.Request, error) {
+					req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+					if err == nil {
+						modify(req)
+					}
+					return req, err
+				},
+				ResponseHandler: func(url string, resp *http.Response) error { return nil },
+				ErrorHandler:    func(url string, err error) { failure = err },
+			})
+			if err := crawler.Run(ctx, func(yield func(string) bool) { yield(server.URL) }); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if failure == nil || !strings.Contains(failure.Error(), "invalid") {
+				t.Errorf("%s %s: expected the request to be rejected, got %v", server.URL, name, failure)
+			}
+		}
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("expected no request to reach the servers, got %d", n)
+	}
+}
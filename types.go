@@ -5,11 +5,24 @@ import (
 	"context"
 	"iter"
 	"net/http"
+	"sync/atomic"
+	"time"
 )
 
 // URLGenerator is a function that yields URLs to crawl using Go 1.23+ iterators.
 type URLGenerator = iter.Seq[string]
 
+// Item is a URL to crawl with metadata, such as a customer ID or priority, that is passed
+// on unchanged: as Result.Meta, Fetch.Meta and Error.Meta, and to the RequestBuilder and
+// ResponseHandler through the request context, see MetaFromContext.
+type Item struct {
+	URL  string
+	Meta any
+}
+
+// ItemGenerator is a function that yields items to crawl.
+type ItemGenerator = iter.Seq[Item]
+
 // RequestBuilder is an optional callback that generates HTTP requests for a given URL.
 // If nil, a default GET request will be used.
 type RequestBuilder func(ctx context.Context, url string) (*http.Request, error)
@@ -18,8 +31,14 @@ type RequestBuilder func(ctx context.Context, url string) (*http.Request, error)
 // If nil, a default handler that prints the status code will be used.
 type ResponseHandler func(url string, resp *http.Response) error
 
+// ResultHandler is an optional callback that processes the Result of a crawled URL,
+// including the response, redirect chain, connection details and timings.
+// If set, it is used instead of the ResponseHandler.
+type ResultHandler func(res *Result) error
+
 // ErrorHandler is an optional callback that handles errors during crawling.
 // If nil, errors will be silently ignored.
+// Errors concerning a URL are an *Error, see KindOf.
 type ErrorHandler func(url string, err error)
 
 // RedirectionPolicy is a function that determines whether to follow a redirect.
@@ -27,26 +46,122 @@ type ErrorHandler func(url string, err error)
 // Return an error to stop following redirects, or nil to continue.
 type RedirectionPolicy func(req *http.Request, via []*http.Request) error
 
+// RetryPolicy decides whether a failed attempt should be retried, and after how long.
+// attempt is 1 for the first try. Either resp or err is set, as returned by the HTTP client;
+// err is an *Error, so it can be classified with KindOf or predicates such as IsTimeout.
+// The response body is closed by the crawler when a retry is requested.
+type RetryPolicy func(url string, attempt int, resp *http.Response, err error) (retry bool, delay time.Duration)
+
 // Config contains configuration options for the crawler.
 type Config struct {
 	// WorkerCount is the number of parallel workers. Default: 10.
 	WorkerCount int
 
+	// MaxPerHost limits the number of concurrent requests to a single host. Default: 0 (no limit).
+	MaxPerHost int
+
+	// HostDelay is the minimum time between the start of two requests to the same host.
+	HostDelay time.Duration
+
+	// QueueSize is the number of URLs read ahead from the generator, so workers can
+	// skip over hosts that are busy or delayed. Default: 100 * WorkerCount.
+	QueueSize int
+
 	// RequestBuilder generates HTTP requests. If nil, uses default GET requests.
 	RequestBuilder RequestBuilder
 
 	// ResponseHandler processes responses. If nil, prints status codes.
 	ResponseHandler ResponseHandler
 
+	// ResultHandler processes results. If set, it is used instead of ResponseHandler.
+	ResultHandler ResultHandler
+
 	// ErrorHandler handles errors. If nil, errors are ignored.
 	ErrorHandler ErrorHandler
 
-	// UserAgent is the User-Agent header to use. If empty, fetches from API.
+	// UserAgent is the User-Agent header to use. If empty, uses the UserAgentProvider.
+	// It is ignored if HeaderProfiles is set.
 	UserAgent string
 
+	// UserAgentProvider supplies the User-Agent if UserAgent and HeaderProfiles are empty.
+	// If it fails, a built-in user agent is used. If nil, uses RemoteUserAgent("").
+	UserAgentProvider UserAgentProvider
+
+	// Offline forbids network calls during New, so RemoteUserAgent fails with ErrOffline.
+	// Wrap it in CachedUserAgent to use the last user agent that was fetched.
+	Offline bool
+
+	// HeaderProfiles are the browser profiles used for request headers. With several
+	// profiles, one is picked per host, so all requests to a host look like the same
+	// browser. Use BrowserProfiles to rotate among all built-in profiles.
+	// If empty, uses Chrome on macOS with the UserAgent.
+	HeaderProfiles []*HeaderProfile
+
+	// OrderedHeaders sends request headers in the exact order of the HeaderProfile, and on
+	// HTTP/2 with the browser's SETTINGS and pseudo-header order. It replaces the transport
+	// of the Client, and every request uses a new connection.
+	OrderedHeaders bool
+
+	// VerifyTLS verifies server certificates, so requests to servers with an invalid
+	// certificate fail. Default: false. Either way, the certificate and its verification
+	// outcome are recorded in Result.Certificate.
+	VerifyTLS bool
+
 	// RedirectionPolicy controls how redirects are handled. If nil, uses DefaultRedirectionPolicy.
 	RedirectionPolicy RedirectionPolicy
 
+	// Robots enables fetching and enforcing robots.txt. Disallowed URLs are reported
+	// to the ErrorHandler as *RobotsError. If nil, robots.txt is ignored.
+	Robots *RobotsPolicy
+
+	// FollowLinks enables recursive crawling of links found in HTML responses.
+	// If nil, only URLs from the generator are fetched.
+	FollowLinks *LinkPolicy
+
+	// Journal records crawl progress on disk. If nil, progress is not recorded.
+	Journal *Journal
+
+	// Resume continues the crawl recorded in the Journal: URLs that were completed
+	// are skipped and URLs that were in flight are requeued. If false, the Journal is reset.
+	Resume bool
+
+	// Timeouts limits the phases of each request, independent of the run context.
+	// An expired timeout is reported as an *Error of KindTimeout wrapping a *TimeoutError.
+	// Default: no limits.
+	Timeouts Timeouts
+
+	// DecodeCharset converts text bodies to UTF-8 before handlers see them. The charset is
+	// taken from the Content-Type, a BOM or a <meta> tag, or sniffed, and recorded in
+	// Result.Charset. Default: false, bodies are passed on as is.
+	DecodeCharset bool
+
+	// MaxBodyBytes caps the response body seen by handlers, after decompression. Reads
+	// end with io.EOF at the limit and Result.Truncated is set if the body was longer.
+	// Default: no limit.
+	MaxBodyBytes int64
+
+	// Middleware wraps every attempt at a URL, the first being the outermost. Middleware
+	// sees the request after the profile headers are set, and the response before the
+	// RetryPolicy and handlers do.
+	Middleware []Middleware
+
+	// Metrics collects metrics about requests, errors, workers and the queue, to be served
+	// in the Prometheus text format. If nil, no metrics are collected.
+	Metrics *Metrics
+
+	// RetryPolicy decides whether failed requests are retried. If nil, requests are not retried.
+	RetryPolicy RetryPolicy
+
+	// ProxyPool spreads requests over several proxies. It is used by the Client's transport
+	// if that is an *http.Transport, or by the transport of OrderedHeaders. If nil, the
+	// transport's own proxy settings apply.
+	ProxyPool *ProxyPool
+
+	// Resolver resolves host names, with caching and overrides. It is used by the Client's
+	// transport if that is an *http.Transport, or by the transport of OrderedHeaders.
+	// If nil, the system resolver is used.
+	Resolver *Resolver
+
 	// Client is the HTTP client to use. If nil, uses http.DefaultClient.
 	Client *http.Client
 }
@@ -55,6 +170,11 @@ type Config struct {
 type Crawler struct {
 	config    Config
 	userAgent string
-	secChUa   string
+	profiles  []*HeaderProfile
 	client    *http.Client
+	verifier  *certVerifier // for Result.Certificate
+	robots    *robotsCache  // nil unless Config.Robots is set
+	fetch     FetchFunc     // the Middleware chain around send
+	handler   ResultHandler
+	stats     atomic.Pointer[runStats] // of the current or last run
 }
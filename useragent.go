@@ -2,9 +2,12 @@ package crawl
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
@@ -13,48 +16,173 @@ import (
 // Default user agent fallback if API is unavailable
 const defaultUserAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/144.0.0.0 Safari/537.36"
 
-// fetchUserAgent retrieves the latest user agent from the Sansec API.
-// Returns the default user agent if the API call fails.
-func fetchUserAgent(ctx context.Context) string {
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
+// DefaultUserAgentURL is the endpoint of RemoteUserAgent that returns the latest Chrome user agent.
+const DefaultUserAgentURL = "https://api.sansec.io/v1/useragent/latest"
 
-	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.sansec.io/v1/useragent/latest", nil)
-	if err != nil {
-		return defaultUserAgent
-	}
+// ErrOffline is returned by providers that need the network when Config.Offline is set.
+var ErrOffline = errors.New("network access disabled by Config.Offline")
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return defaultUserAgent
-	}
-	defer resp.Body.Close()
+// UserAgentProvider supplies the User-Agent when neither Config.UserAgent nor
+// Config.HeaderProfiles is set. It is called once, by New.
+type UserAgentProvider interface {
+	UserAgent(ctx context.Context) (string, error)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return defaultUserAgent
+// UserAgentFunc is a function that implements UserAgentProvider.
+type UserAgentFunc func(ctx context.Context) (string, error)
+
+// UserAgent calls f.
+func (f UserAgentFunc) UserAgent(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// StaticUserAgent returns a provider that always returns userAgent.
+func StaticUserAgent(userAgent string) UserAgentProvider {
+	return UserAgentFunc(func(context.Context) (string, error) {
+		return userAgent, nil
+	})
+}
+
+// RemoteUserAgent returns a provider that fetches the user agent from url, which responds
+// with a plain text user agent. If url is empty, uses DefaultUserAgentURL.
+// It fails with ErrOffline if Config.Offline is set.
+func RemoteUserAgent(url string) UserAgentProvider {
+	if url == "" {
+		url = DefaultUserAgentURL
 	}
+	return UserAgentFunc(func(ctx context.Context) (string, error) {
+		if IsOffline(ctx) {
+			return "", ErrOffline
+		}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return defaultUserAgent
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to create request for %s: %w", url, err)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("failed to fetch user agent: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("failed to fetch user agent from %s: %s", url, resp.Status)
+		}
+
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if err != nil {
+			return "", fmt.Errorf("failed to read user agent from %s: %w", url, err)
+		}
+		return parseUserAgent(string(body), url)
+	})
+}
+
+// FileUserAgent returns a provider that reads the user agent from the first line of
+// the file at path that is not empty or a # comment.
+func FileUserAgent(path string) UserAgentProvider {
+	return UserAgentFunc(func(context.Context) (string, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read user agent: %w", err)
+		}
+		return parseUserAgent(string(data), path)
+	})
+}
+
+// CachedUserAgent returns a provider that stores the last user agent of provider in the
+// file at path, and returns it without calling provider while it is younger than ttl.
+// If provider fails, the cached user agent is returned however old it is, so runs
+// without network keep using the last good one.
+// The cache is best effort: if it cannot be written, the fetched user agent is still returned.
+// If path is empty, uses crawl/useragent in the user's cache directory. If ttl is zero, uses 24 hours.
+func CachedUserAgent(provider UserAgentProvider, path string, ttl time.Duration) UserAgentProvider {
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
 	}
+	return UserAgentFunc(func(ctx context.Context) (string, error) {
+		path := path
+		if path == "" {
+			dir, err := os.UserCacheDir()
+			if err != nil {
+				return provider.UserAgent(ctx)
+			}
+			path = filepath.Join(dir, "crawl", "useragent")
+		}
 
-	userAgent := strings.TrimSpace(string(body))
-	if userAgent == "" {
-		return defaultUserAgent
+		cached, cacheErr := FileUserAgent(path).UserAgent(ctx)
+		if cacheErr == nil {
+			if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) < ttl {
+				return cached, nil
+			}
+		}
+
+		userAgent, err := provider.UserAgent(ctx)
+		if err != nil {
+			if cacheErr == nil {
+				return cached, nil
+			}
+			return "", err
+		}
+
+		if tmp, err := writeTemp(filepath.Dir(path), strings.NewReader(userAgent+"\n"), false); err == nil {
+			if err := os.Rename(tmp, path); err != nil {
+				os.Remove(tmp) //nolint:errcheck
+			}
+		}
+		return userAgent, nil
+	})
+}
+
+// parseUserAgent returns the first line of data that is not empty or a # comment.
+func parseUserAgent(data, source string) (string, error) {
+	for line := range strings.Lines(data) {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return line, nil
+		}
 	}
+	return "", fmt.Errorf("no user agent in %s", source)
+}
 
-	return userAgent
+type offlineKey struct{}
+
+// withOffline marks ctx as forbidding network access.
+func withOffline(ctx context.Context) context.Context {
+	return context.WithValue(ctx, offlineKey{}, true)
+}
+
+// IsOffline reports whether ctx forbids network access, because Config.Offline is set.
+// A custom UserAgentProvider that needs the network should then fail with ErrOffline.
+func IsOffline(ctx context.Context) bool {
+	offline, _ := ctx.Value(offlineKey{}).(bool)
+	return offline
 }
 
 // getUserAgent returns the user agent to use for the crawler.
 // If a user agent is provided in the config, it uses that.
-// Otherwise, it fetches the latest from the API.
+// Otherwise, it asks the UserAgentProvider, which fetches the latest from the API by default.
+// Returns the default user agent if the provider fails.
 func getUserAgent(ctx context.Context, config Config) string {
 	if config.UserAgent != "" {
 		return config.UserAgent
 	}
-	return fetchUserAgent(ctx)
+
+	provider := config.UserAgentProvider
+	if provider == nil {
+		provider = RemoteUserAgent("")
+	}
+	if config.Offline {
+		ctx = withOffline(ctx)
+	}
+	userAgent, err := provider.UserAgent(ctx)
+	if err != nil {
+		return defaultUserAgent
+	}
+	return userAgent
 }
 
 // extractChromeVersion extracts the Chrome version from a user agent string.
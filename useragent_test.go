@@ -0,0 +1,119 @@
+package crawl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUserAgentProviders(t *testing.T) {
+	ctx := context.Background()
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		io.WriteString(w, "remote/1.0\n") //nolint:errcheck
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "ua.txt")
+	os.WriteFile(file, []byte("# comment\n\nfile/1.0\n"), 0o644) //nolint:errcheck
+
+	tests := []struct {
+		name     string
+		provider UserAgentProvider
+		expected string
+	}{
+		{"static", StaticUserAgent("static/1.0"), "static/1.0"},
+		{"remote", RemoteUserAgent(server.URL), "remote/1.0"},
+		{"file", FileUserAgent(file), "file/1.0"},
+	}
+	for _, tt := range tests {
+		ua, err := tt.provider.UserAgent(ctx)
+		if err != nil || ua != tt.expected {
+			t.Errorf("%s: expected %q, got %q, %v", tt.name, tt.expected, ua, err)
+		}
+	}
+
+	if _, err := FileUserAgent(filepath.Join(t.TempDir(), "missing")).UserAgent(ctx); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+
+	requests.Store(0)
+	if _, err := RemoteUserAgent(server.URL).UserAgent(withOffline(ctx)); !errors.Is(err, ErrOffline) {
+		t.Errorf("expected ErrOffline, got %v", err)
+	}
+	crawler := New(ctx, Config{UserAgentProvider: RemoteUserAgent(server.URL), Offline: true})
+	if crawler.userAgent != defaultUserAgent || requests.Load() != 0 {
+		t.Errorf("expected the default user agent without requests, got %q after %d requests", crawler.userAgent, requests.Load())
+	}
+
+	// Custom providers see Offline too
+	custom := UserAgentFunc(func(ctx context.Context) (string, error) {
+		if IsOffline(ctx) {
+			return "offline/1.0", nil
+		}
+		return "online/1.0", nil
+	})
+	if ua := New(ctx, Config{UserAgentProvider: custom, Offline: true}).userAgent; ua != "offline/1.0" {
+		t.Errorf("expected the provider to see Offline, got %q", ua)
+	}
+}
+
+func TestCachedUserAgent(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "crawl", "useragent")
+
+	var calls int
+	var fail bool
+	provider := UserAgentFunc(func(context.Context) (string, error) {
+		calls++
+		if fail {
+			return "", errors.New("unreachable")
+		}
+		return "fresh/1.0", nil
+	})
+	cached := CachedUserAgent(provider, path, time.Hour)
+
+	// Fetched and stored, then served from the cache
+	for range 2 {
+		if ua, err := cached.UserAgent(ctx); err != nil || ua != "fresh/1.0" {
+			t.Fatalf("expected fresh/1.0, got %q, %v", ua, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+
+	// Expired, and the provider fails: the last good user agent is used
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(path, old, old) //nolint:errcheck
+	fail = true
+	if ua, err := cached.UserAgent(ctx); err != nil || ua != "fresh/1.0" {
+		t.Errorf("expected the stale user agent, got %q, %v", ua, err)
+	}
+	if calls != 2 {
+		t.Errorf("expected the provider to be asked again, got %d calls", calls)
+	}
+
+	// An unwritable cache does not discard the fetched user agent
+	notDir := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(notDir, nil, 0o644) //nolint:errcheck
+	fail = false
+	if ua, err := CachedUserAgent(provider, filepath.Join(notDir, "ua"), time.Hour).UserAgent(ctx); err != nil || ua != "fresh/1.0" {
+		t.Errorf("expected the fetched user agent, got %q, %v", ua, err)
+	}
+	fail = true
+
+	// No cache and a failing provider
+	if _, err := CachedUserAgent(provider, filepath.Join(t.TempDir(), "ua"), time.Hour).UserAgent(ctx); err == nil {
+		t.Error("expected an error without a cached user agent")
+	}
+}
@@ -0,0 +1,427 @@
+package crawl
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // WARC digests are SHA-1 by convention
+	"encoding/base32"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultWARCFileSize = 1 << 30
+
+// WARCOptions configures a WARCWriter.
+type WARCOptions struct {
+	// Prefix is the start of each file name. Default: "crawl".
+	Prefix string
+
+	// MaxFileSize is the size in bytes after which a new file is started. Default: 1 GiB.
+	MaxFileSize int64
+}
+
+// WARCWriter archives requests and responses as WARC 1.1 files.
+// Every record is compressed as a separate gzip member, so the files can
+// be read with standard WARC tools. It is safe for concurrent use.
+type WARCWriter struct {
+	dir  string
+	opts WARCOptions
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	serial int
+}
+
+// NewWARCWriter creates a WARCWriter that writes files to dir.
+// Files are named <prefix>-<timestamp>-<serial>.warc.gz and rotated after MaxFileSize.
+func NewWARCWriter(dir string, opts WARCOptions) (*WARCWriter, error) {
+	if opts.Prefix == "" {
+		opts.Prefix = "crawl"
+	}
+	if opts.MaxFileSize <= 0 {
+		opts.MaxFileSize = defaultWARCFileSize
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	return &WARCWriter{dir: dir, opts: opts}, nil
+}
+
+// Handle is a ResponseHandler that archives the response and its request.
+// The body is read completely and replaced by an in-memory copy,
+// so it can be passed on to another handler.
+func (w *WARCWriter) Handle(url string, resp *http.Response) error {
+	return w.HandleResult(&Result{URL: url, Response: resp})
+}
+
+// HandleResult is a ResultHandler that archives the response, its request and
+// a metadata record with the redirect chain, connection details and timings.
+// The body is read completely and replaced by an in-memory copy.
+func (w *WARCWriter) HandleResult(res *Result) error {
+	resp := res.Response
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	target := res.URL
+	if resp.Request != nil {
+		target = resp.Request.URL.String()
+	}
+	date := time.Now().UTC()
+	responseID := newRecordID()
+
+	var records bytes.Buffer
+
+	if resp.Request != nil {
+		reqHeader := warcHeader{
+			{"WARC-Type", "request"},
+			{"WARC-Record-ID", newRecordID()},
+			{"WARC-Date", date.Format(time.RFC3339Nano)},
+			{"WARC-Target-URI", target},
+			{"WARC-Concurrent-To", responseID},
+			{"Content-Type", "application/http;msgtype=request"},
+		}
+		if err := writeRecord(&records, reqHeader, requestBlock(resp.Request)); err != nil {
+			return err
+		}
+	}
+
+	respHeader := warcHeader{
+		{"WARC-Type", "response"},
+		{"WARC-Record-ID", responseID},
+		{"WARC-Date", date.Format(time.RFC3339Nano)},
+		{"WARC-Target-URI", target},
+	}
+	if ip, _, err := net.SplitHostPort(res.RemoteAddr); err == nil {
+		respHeader = append(respHeader, warcField{"WARC-IP-Address", ip})
+	}
+	respHeader = append(respHeader, warcField{"WARC-Payload-Digest", digest(body)})
+	if res.Truncated {
+		respHeader = append(respHeader, warcField{"WARC-Truncated", "length"})
+	}
+	respHeader = append(respHeader, warcField{"Content-Type", "application/http;msgtype=response"})
+	if err := writeRecord(&records, respHeader, responseBlock(resp, body)); err != nil {
+		return err
+	}
+
+	if meta := resultMetadata(res); len(meta) > 0 {
+		metaHeader := warcHeader{
+			{"WARC-Type", "metadata"},
+			{"WARC-Record-ID", newRecordID()},
+			{"WARC-Date", date.Format(time.RFC3339Nano)},
+			{"WARC-Target-URI", target},
+			{"WARC-Refers-To", responseID},
+			{"Content-Type", "application/warc-fields"},
+		}
+		if err := writeRecord(&records, metaHeader, meta); err != nil {
+			return err
+		}
+	}
+
+	return w.write(records.Bytes())
+}
+
+// write appends complete records to the current file, rotating it if needed.
+func (w *WARCWriter) write(records []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+
+	n, err := w.file.Write(records)
+	w.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write to %s: %w", w.file.Name(), err)
+	}
+
+	if w.size >= w.opts.MaxFileSize {
+		return w.closeFile()
+	}
+	return nil
+}
+
+// open starts a new file with a warcinfo record. Must be called with mu held.
+func (w *WARCWriter) open() error {
+	w.serial++
+	name := fmt.Sprintf("%s-%s-%05d.warc.gz", w.opts.Prefix, time.Now().UTC().Format("20060102150405"), w.serial)
+	path := filepath.Join(w.dir, name)
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", path, err)
+	}
+
+	var info bytes.Buffer
+	header := warcHeader{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Record-ID", newRecordID()},
+		{"WARC-Date", time.Now().UTC().Format(time.RFC3339Nano)},
+		{"WARC-Filename", name},
+		{"Content-Type", "application/warc-fields"},
+	}
+	fields := []byte("software: github.com/gwillem/crawl\r\nformat: WARC File Format 1.1\r\n")
+	if err := writeRecord(&info, header, fields); err != nil {
+		file.Close() //nolint:errcheck
+		return err
+	}
+	n, err := file.Write(info.Bytes())
+	if err != nil {
+		file.Close() //nolint:errcheck
+		return fmt.Errorf("failed to write to %s: %w", path, err)
+	}
+
+	w.file = file
+	w.size = int64(n)
+	return nil
+}
+
+// closeFile closes the current file. Must be called with mu held.
+func (w *WARCWriter) closeFile() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// Close closes the current file.
+func (w *WARCWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.closeFile()
+}
+
+// warcField is a single WARC header field.
+type warcField struct {
+	name, value string
+}
+
+// warcHeader is an ordered list of WARC header fields.
+type warcHeader []warcField
+
+// writeRecord writes a single gzip-compressed WARC record.
+func writeRecord(w io.Writer, header warcHeader, block []byte) error {
+	zw := gzip.NewWriter(w)
+
+	var buf bytes.Buffer
+	buf.WriteString("WARC/1.1\r\n")
+	for _, f := range header {
+		fmt.Fprintf(&buf, "%s: %s\r\n", f.name, f.value)
+	}
+	fmt.Fprintf(&buf, "WARC-Block-Digest: %s\r\n", digest(block))
+	fmt.Fprintf(&buf, "Content-Length: %d\r\n\r\n", len(block))
+	buf.Write(block)
+	buf.WriteString("\r\n\r\n")
+
+	if _, err := zw.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// responseBlock serializes the status line, headers and body of a response. The body may
+// have been decoded, converted to UTF-8 or truncated by the crawler, so the headers are
+// made to describe the stored body: no transfer or content coding, and its actual length.
+func responseBlock(resp *http.Response, body []byte) []byte {
+	var buf bytes.Buffer
+	proto := resp.Proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	status := resp.Status
+	if status == "" {
+		status = strconv.Itoa(resp.StatusCode) + " " + http.StatusText(resp.StatusCode)
+	}
+	fmt.Fprintf(&buf, "%s %s\r\n", proto, status)
+	header := resp.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if resp.Uncompressed {
+		header.Del("Content-Encoding")
+	}
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	header.Write(&buf) //nolint:errcheck
+	buf.WriteString("\r\n")
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+// requestBlock serializes the request line and headers of a request.
+func requestBlock(req *http.Request) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s HTTP/1.1\r\n", req.Method, req.URL.RequestURI())
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	fmt.Fprintf(&buf, "Host: %s\r\n", host)
+	req.Header.Write(&buf) //nolint:errcheck
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+// resultMetadata returns the warc-fields for a metadata record describing res.
+func resultMetadata(res *Result) []byte {
+	var buf bytes.Buffer
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+		}
+	}
+	if len(res.Redirects) > 0 {
+		field("via", res.URL)
+	}
+	for _, hop := range res.Redirects {
+		field("redirect", fmt.Sprintf("%d %s", hop.StatusCode, hop.URL))
+	}
+	field("protocol", res.Protocol)
+	field("contentEncoding", res.ContentEncoding)
+	field("charset", res.Charset)
+	field("tlsVersion", res.TLSVersion)
+	field("tlsCipher", res.TLSCipher)
+	if res.Timings.TTFB > 0 {
+		field("ttfbMs", strconv.FormatInt(res.Timings.TTFB.Milliseconds(), 10))
+	}
+	if res.Attempts > 1 {
+		field("attempts", strconv.Itoa(res.Attempts))
+	}
+	return buf.Bytes()
+}
+
+// digest returns the WARC digest of data: sha1 in base32.
+func digest(data []byte) string {
+	sum := sha1.Sum(data) //nolint:gosec
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// newRecordID returns a random WARC-Record-ID.
+func newRecordID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// ReplayWARC reads a WARC file, gzip-compressed or not, and passes every response
+// record to handler, as if it was just fetched. The matching request record,
+// if any, is available as resp.Request. It stops at the first handler error.
+func ReplayWARC(path string, handler ResponseHandler) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	br := bufio.NewReader(file)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		defer zr.Close() //nolint:errcheck
+		br = bufio.NewReader(zr)
+	}
+
+	requests := make(map[string]*http.Request) // by the ID of the response they belong to
+	for {
+		header, block, err := readRecord(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		target := header.Get("WARC-Target-URI")
+		switch header.Get("WARC-Type") {
+		case "request":
+			req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(block)))
+			if err != nil {
+				return fmt.Errorf("invalid request record for %s: %w", target, err)
+			}
+			if u, err := url.Parse(target); err == nil {
+				req.URL = u
+			}
+			requests[header.Get("WARC-Concurrent-To")] = req
+		case "response":
+			id := header.Get("WARC-Record-ID")
+			resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(block)), nil)
+			if err != nil {
+				return fmt.Errorf("invalid response record for %s: %w", target, err)
+			}
+			resp.Request = requests[id]
+			delete(requests, id)
+			if resp.Request == nil {
+				resp.Request, _ = http.NewRequest("GET", target, nil)
+			}
+
+			err = handler(target, resp)
+			resp.Body.Close() //nolint:errcheck
+			if err != nil {
+				return fmt.Errorf("handler failed for %s: %w", target, err)
+			}
+		}
+	}
+}
+
+// readRecord reads the next WARC record from r.
+func readRecord(r *bufio.Reader) (textproto.MIMEHeader, []byte, error) {
+	tp := textproto.NewReader(r)
+
+	var version string
+	for version == "" {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return nil, nil, err
+		}
+		version = strings.TrimSpace(line)
+	}
+	if !strings.HasPrefix(version, "WARC/") {
+		return nil, nil, fmt.Errorf("invalid record start %q", version)
+	}
+
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, nil, err
+	}
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid Content-Length: %w", err)
+	}
+	if length < 0 {
+		return nil, nil, fmt.Errorf("invalid Content-Length %d", length)
+	}
+
+	// Read rather than allocate up front, so a bogus length cannot exhaust memory
+	block, err := io.ReadAll(io.LimitReader(r, length))
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(block)) < length {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	return header, block, nil
+}
@@ -0,0 +1,244 @@
+package crawl
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWARCWriter(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusFound)
+			return
+		}
+		w.Header().Set("X-Test", "yes")
+		fmt.Fprintf(w, "page %s", r.URL.Path)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	warc, err := NewWARCWriter(dir, WARCOptions{Prefix: "test"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	crawler := New(ctx, Config{
+		WorkerCount:   3,
+		UserAgent:     "test",
+		ResultHandler: warc.HandleResult,
+	})
+
+	urls := func(yield func(string) bool) {
+		for _, path := range []string{"/a", "/b", "/old"} {
+			if !yield(server.URL + path) {
+				return
+			}
+		}
+	}
+	if err := crawler.Run(ctx, urls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := warc.Close(); err != nil {
+		t.Fatalf("expected no error closing, got %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "test-*.warc.gz"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 WARC file, got %v", files)
+	}
+
+	t.Run("records", func(t *testing.T) {
+		f, _ := os.Open(files[0])
+		defer f.Close()
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("expected gzip file, got %v", err)
+		}
+
+		counts := map[string]int{}
+		br := bufio.NewReader(zr)
+		for {
+			header, _, err := readRecord(br)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("failed to read record: %v", err)
+			}
+			counts[header.Get("WARC-Type")]++
+			if header.Get("WARC-Type") == "response" && !strings.HasPrefix(header.Get("WARC-Payload-Digest"), "sha1:") {
+				t.Errorf("expected payload digest, got %q", header.Get("WARC-Payload-Digest"))
+			}
+		}
+
+		expected := map[string]int{"warcinfo": 1, "request": 3, "response": 3, "metadata": 3}
+		for typ, n := range expected {
+			if counts[typ] != n {
+				t.Errorf("expected %d %s records, got %d", n, typ, counts[typ])
+			}
+		}
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		var mu sync.Mutex
+		bodies := map[string]string{}
+		err := ReplayWARC(files[0], func(url string, resp *http.Response) error {
+			body, _ := io.ReadAll(resp.Body)
+			mu.Lock()
+			defer mu.Unlock()
+			bodies[url] = string(body)
+			if resp.Header.Get("X-Test") != "yes" {
+				t.Errorf("expected X-Test header for %s", url)
+			}
+			if resp.Request == nil || resp.Request.Header.Get("User-Agent") != "test" {
+				t.Errorf("expected request with User-Agent for %s", url)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if bodies[server.URL+"/a"] != "page /a" || bodies[server.URL+"/new"] != "page /new" {
+			t.Errorf("unexpected replayed bodies: %v", bodies)
+		}
+	})
+}
+
+func TestWARCWriterStoredBody(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gzip":
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			zw.Write([]byte("hello gzip")) //nolint:errcheck
+			zw.Close()                     //nolint:errcheck
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+			w.Write(buf.Bytes()) //nolint:errcheck
+		case "/long":
+			w.Header().Set("Content-Length", "100")
+			w.Write(bytes.Repeat([]byte("x"), 100)) //nolint:errcheck
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	warc, _ := NewWARCWriter(dir, WARCOptions{})
+	crawler := New(ctx, Config{
+		UserAgent:     "test",
+		MaxBodyBytes:  20,
+		ResultHandler: warc.HandleResult,
+	})
+	urls := func(yield func(string) bool) {
+		_ = yield(server.URL+"/gzip") && yield(server.URL+"/long")
+	}
+	if err := crawler.Run(ctx, urls); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	warc.Close() //nolint:errcheck
+
+	// Replay sniffs gzip rather than relying on the file name
+	files, _ := filepath.Glob(filepath.Join(dir, "*.warc.gz"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 WARC file, got %v", files)
+	}
+	renamed := filepath.Join(dir, "archive.warc")
+	os.Rename(files[0], renamed) //nolint:errcheck
+
+	// Headers describe the stored body, which was decoded or truncated
+	bodies := map[string]string{}
+	err := ReplayWARC(renamed, func(url string, resp *http.Response) error {
+		body, _ := io.ReadAll(resp.Body)
+		bodies[url] = string(body)
+		if resp.Header.Get("Content-Encoding") != "" || resp.ContentLength != int64(len(body)) {
+			t.Errorf("%s: unexpected headers for a body of %d bytes: %v", url, len(body), resp.Header)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if bodies[server.URL+"/gzip"] != "hello gzip" || bodies[server.URL+"/long"] != strings.Repeat("x", 20) {
+		t.Errorf("unexpected replayed bodies: %v", bodies)
+	}
+
+	f, _ := os.Open(renamed)
+	defer f.Close()
+	zr, _ := gzip.NewReader(f)
+	br := bufio.NewReader(zr)
+	truncated := map[string]string{}
+	for {
+		header, _, err := readRecord(br)
+		if err != nil {
+			break
+		}
+		if header.Get("WARC-Type") == "response" {
+			truncated[header.Get("WARC-Target-URI")] = header.Get("WARC-Truncated")
+		}
+	}
+	if truncated[server.URL+"/long"] != "length" || truncated[server.URL+"/gzip"] != "" {
+		t.Errorf("expected only /long to be truncated, got %v", truncated)
+	}
+}
+
+func TestWARCWriterRotation(t *testing.T) {
+	dir := t.TempDir()
+	warc, err := NewWARCWriter(dir, WARCOptions{MaxFileSize: 1})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", "https://example.com/", nil)
+		resp := &http.Response{
+			StatusCode: 200,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader("content")),
+			Request:    req,
+		}
+		if err := warc.Handle(req.URL.String(), resp); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if body, _ := io.ReadAll(resp.Body); string(body) != "content" {
+			t.Errorf("expected body to be restored, got %q", body)
+		}
+	}
+	warc.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "crawl-*.warc.gz"))
+	if len(files) != 3 {
+		t.Errorf("expected 3 rotated files, got %d", len(files))
+	}
+}
+
+func TestReplayWARCInvalidLength(t *testing.T) {
+	dir := t.TempDir()
+	for name, length := range map[string]string{"negative": "-1", "huge": "9223372036854775807"} {
+		path := filepath.Join(dir, name+".warc")
+		record := "WARC/1.1\r\nWARC-Type: response\r\nContent-Length: " + length + "\r\n\r\nshort"
+		if err := os.WriteFile(path, []byte(record), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		err := ReplayWARC(path, func(url string, resp *http.Response) error { return nil })
+		if err == nil {
+			t.Errorf("%s: expected an error for Content-Length %s", name, length)
+		}
+	}
+}